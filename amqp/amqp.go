@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"time"
+
+	"github.com/DeBankDeFi/golib/util"
 
 	log "github.com/DeBankDeFi/glog"
 	"github.com/streadway/amqp"
@@ -58,7 +61,9 @@ type Error amqp.Error
 // Channel amqp channel abstraction.
 type Channel struct {
 	*amqp.Channel
-	c *Connection
+	c       *Connection
+	breaker *PublishBreaker
+	auditor *Auditor
 }
 
 // NotifyClose notify error to listener while channel is closing.
@@ -73,12 +78,47 @@ func (ch *Channel) NotifyClose(c chan *Error) chan *Error {
 	return c
 }
 
-// Publish publish a message.
-func (ch *Channel) Publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (err error) {
+// Publish publish a message, options are applied to the message in order before publishing.
+// Use WithTraceID to carry the trace id of ctx in the message.
+func (ch *Channel) Publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing, opts ...PublishOption) (err error) {
 	if msg.Headers == nil {
 		msg.Headers = amqp.Table{}
 	}
-	return ch.Channel.Publish(exchange, key, mandatory, immediate, (amqp.Publishing)(msg))
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&msg)
+		}
+	}
+	// audit records fall back to the trace id of ctx, which is not sent unless asked for
+	traceID := traceIDOf(msg.Headers)
+	if traceID == "" {
+		traceID = util.GetTraceIDFromContext(ctx)
+	}
+
+	start := time.Now()
+	rec := &spillRecord{Exchange: exchange, Key: key, Mandatory: mandatory, Immediate: immediate, Msg: msg}
+	if ch.breaker != nil {
+		err = ch.breaker.publish(rec, ch.publishRecord)
+	} else {
+		err = ch.publishRecord(rec)
+	}
+	if ch.auditor != nil {
+		ch.auditor.record(ctx, &AuditRecord{
+			Time:          start,
+			Direction:     AuditPublish,
+			TraceID:       traceID,
+			Exchange:      exchange,
+			RoutingKey:    key,
+			MessageID:     msg.MessageId,
+			CorrelationID: msg.CorrelationId,
+			Duration:      time.Since(start),
+		}, msg.Headers, msg.Body, err)
+	}
+	return err
+}
+
+func (ch *Channel) publishRecord(rec *spillRecord) error {
+	return ch.Channel.Publish(rec.Exchange, rec.Key, rec.Mandatory, rec.Immediate, (amqp.Publishing)(rec.Msg))
 }
 
 type Delivery struct {
@@ -106,11 +146,24 @@ type DeliveryArgs struct {
 	Nowait    bool
 	// Args arguments.
 	Args amqp.Table
+	// Stream consumes a stream queue from an offset, optional.
+	Stream *StreamArgs
 }
 
 // Delivery get delivery chan from underlying amqp channel.
 func (ch *Channel) Delivery(args *DeliveryArgs) (<-chan amqp.Delivery, error) {
-	return ch.Channel.Consume(args.Queue, args.ConsumerTag, args.AutoAck, args.Exclusive, false, args.Nowait, args.Args)
+	return ch.delivery(context.Background(), args)
+}
+
+func (ch *Channel) delivery(ctx context.Context, args *DeliveryArgs) (<-chan amqp.Delivery, error) {
+	table := args.Args
+	if args.Stream != nil {
+		var err error
+		if table, err = ch.streamArgs(ctx, args); err != nil {
+			return nil, err
+		}
+	}
+	return ch.Channel.Consume(args.Queue, args.ConsumerTag, args.AutoAck, args.Exclusive, false, args.Nowait, table)
 }
 
 type Handler func(ctx context.Context, ch *Channel, d *Delivery) error
@@ -139,5 +192,26 @@ func (ch *Channel) Consume(ctx context.Context, queue string, handler Handler, d
 }
 
 func (ch *Channel) consume(queue string, handler Handler, d *Delivery) (err error) {
-	return handler(context.Background(), ch, d)
+	ctx := context.Background()
+	traceID := traceIDOf(d.Headers)
+	if traceID != "" {
+		ctx = util.SetTraceIDToContext(ctx, traceID)
+	}
+
+	start := time.Now()
+	err = handler(ctx, ch, d)
+	if ch.auditor != nil {
+		ch.auditor.record(ctx, &AuditRecord{
+			Time:          start,
+			Direction:     AuditConsume,
+			TraceID:       traceID,
+			Exchange:      d.Exchange,
+			RoutingKey:    d.RoutingKey,
+			Queue:         queue,
+			MessageID:     d.MessageId,
+			CorrelationID: d.CorrelationId,
+			Duration:      time.Since(start),
+		}, d.Headers, d.Body, err)
+	}
+	return err
 }
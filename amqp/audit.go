@@ -0,0 +1,237 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DeBankDeFi/golib/util"
+
+	"github.com/streadway/amqp"
+)
+
+const defaultAuditMaxBody = 512
+
+// AuditDirection whether an audited message was published or consumed.
+type AuditDirection string
+
+const (
+	AuditPublish AuditDirection = "publish"
+	AuditConsume AuditDirection = "consume"
+)
+
+// Outcomes of an audited message.
+const (
+	AuditOutcomeOK    = "ok"
+	AuditOutcomeError = "error"
+)
+
+// AuditRecord a sampled message with its routing info and outcome.
+type AuditRecord struct {
+	Time          time.Time              `json:"time"`
+	Direction     AuditDirection         `json:"direction"`
+	TraceID       string                 `json:"trace_id,omitempty"`
+	Exchange      string                 `json:"exchange"`
+	RoutingKey    string                 `json:"routing_key"`
+	Queue         string                 `json:"queue,omitempty"`
+	MessageID     string                 `json:"message_id,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	Body          string                 `json:"body"`
+	BodySize      int                    `json:"body_size"`
+	Truncated     bool                   `json:"truncated,omitempty"`
+	Outcome       string                 `json:"outcome"`
+	Error         string                 `json:"error,omitempty"`
+	Duration      time.Duration          `json:"duration"`
+}
+
+// AuditSink receives sampled audit records, implementations must be safe for concurrent use.
+type AuditSink interface {
+	Write(rec *AuditRecord) error
+}
+
+// AuditConfig configuration of an auditor.
+type AuditConfig struct {
+	// Sink receives the sampled records, required.
+	Sink AuditSink
+	// SampleRate fraction of messages audited, from 0 to 1. Messages with a trace id are sampled
+	// by hashing it, so all messages of a trace are either audited or not.
+	SampleRate float64
+	// MaxBody bytes of the body kept in a record, default 512.
+	MaxBody int
+}
+
+// Auditor samples published and consumed messages into a sink.
+type Auditor struct {
+	cfg AuditConfig
+}
+
+// NewAuditor creates a new auditor.
+func NewAuditor(cfg AuditConfig) *Auditor {
+	if cfg.MaxBody <= 0 {
+		cfg.MaxBody = defaultAuditMaxBody
+	}
+	return &Auditor{cfg: cfg}
+}
+
+// sampled reports whether a message of the trace should be audited.
+func (a *Auditor) sampled(traceID string) bool {
+	if a.cfg.SampleRate <= 0 {
+		return false
+	}
+	if a.cfg.SampleRate >= 1 {
+		return true
+	}
+	if traceID == "" {
+		return rand.Float64() < a.cfg.SampleRate
+	}
+	h := fnv.New32a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum32()%10000) < a.cfg.SampleRate*10000
+}
+
+func (a *Auditor) record(ctx context.Context, rec *AuditRecord, headers amqp.Table, body []byte, err error) {
+	if !a.sampled(rec.TraceID) {
+		return
+	}
+	if len(headers) > 0 {
+		rec.Headers = make(map[string]interface{}, len(headers))
+		for k, v := range headers {
+			rec.Headers[k] = v
+		}
+	}
+	rec.BodySize = len(body)
+	if len(body) > a.cfg.MaxBody {
+		body = truncateUTF8(body, a.cfg.MaxBody)
+		rec.Truncated = true
+	}
+	rec.Body = string(body)
+	rec.Outcome = AuditOutcomeOK
+	if err != nil {
+		rec.Outcome = AuditOutcomeError
+		rec.Error = err.Error()
+	}
+	if werr := a.cfg.Sink.Write(rec); werr != nil {
+		logger.Warnf(ctx, "amqp: write audit record failed, reason: %v", werr.Error())
+	}
+}
+
+// truncateUTF8 cuts body to at most n bytes without splitting a multi-byte rune of a utf-8
+// body, binary bodies are cut at n.
+func truncateUTF8(body []byte, n int) []byte {
+	if !utf8.Valid(body) {
+		return body[:n]
+	}
+	body = body[:n]
+	// drop the incomplete rune at the end
+	for i := 0; i < utf8.UTFMax && len(body) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(body); r != utf8.RuneError || size != 1 {
+			break
+		}
+		body = body[:len(body)-1]
+	}
+	return body
+}
+
+// SetAuditor samples messages published and consumed through the channel, nil disables auditing.
+func (ch *Channel) SetAuditor(a *Auditor) {
+	ch.auditor = a
+}
+
+// traceIDOf returns the trace id carried in message headers.
+func traceIDOf(headers amqp.Table) string {
+	if tid, ok := headers[util.TraceID].(string); ok {
+		return tid
+	}
+	return ""
+}
+
+// JSONLinesAuditSink writes each record as a json line.
+type JSONLinesAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLinesAuditSink creates a sink writing json lines to w.
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{w: w}
+}
+
+// NewFileAuditSink creates a sink appending json lines to the file at path.
+func NewFileAuditSink(path string) (*JSONLinesAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesAuditSink{w: f}, nil
+}
+
+// Write ...
+func (s *JSONLinesAuditSink) Write(rec *AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Close closes the underlying writer if it is closable.
+func (s *JSONLinesAuditSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// MemoryAuditSink keeps the latest records in memory.
+type MemoryAuditSink struct {
+	mu      sync.Mutex
+	size    int
+	records []AuditRecord
+}
+
+// NewMemoryAuditSink creates a sink keeping at most size records, 0 means unbounded.
+func NewMemoryAuditSink(size int) *MemoryAuditSink {
+	return &MemoryAuditSink{size: size}
+}
+
+// Write ...
+func (s *MemoryAuditSink) Write(rec *AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, *rec)
+	if s.size > 0 && len(s.records) > s.size {
+		s.records = s.records[len(s.records)-s.size:]
+	}
+	return nil
+}
+
+// Records returns the kept records in write order.
+func (s *MemoryAuditSink) Records() []AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditRecord(nil), s.records...)
+}
+
+// Trace returns the kept records of a trace in write order.
+func (s *MemoryAuditSink) Trace(traceID string) []AuditRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []AuditRecord
+	for _, rec := range s.records {
+		if rec.TraceID == traceID {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
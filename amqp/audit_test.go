@@ -0,0 +1,82 @@
+package amqp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/util"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditor_Consume(t *testing.T) {
+	sink := NewMemoryAuditSink(10)
+	ch := &Channel{}
+	ch.SetAuditor(NewAuditor(AuditConfig{Sink: sink, SampleRate: 1, MaxBody: 4}))
+
+	handler := func(ctx context.Context, ch *Channel, d *Delivery) error {
+		require.Equal(t, "tid-1", util.GetTraceIDFromContext(ctx))
+		return errors.New("boom")
+	}
+	err := ch.consume("q", handler, &Delivery{&amqp.Delivery{
+		Exchange:   "ex",
+		RoutingKey: "rk",
+		Headers:    amqp.Table{util.TraceID: "tid-1"},
+		Body:       []byte("0123456789"),
+	}})
+	require.Error(t, err)
+
+	records := sink.Trace("tid-1")
+	require.Len(t, records, 1)
+	rec := records[0]
+	require.Equal(t, AuditConsume, rec.Direction)
+	require.Equal(t, "q", rec.Queue)
+	require.Equal(t, "rk", rec.RoutingKey)
+	require.Equal(t, "0123", rec.Body)
+	require.Equal(t, 10, rec.BodySize)
+	require.True(t, rec.Truncated)
+	require.Equal(t, AuditOutcomeError, rec.Outcome)
+	require.Equal(t, "boom", rec.Error)
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	// "日" is 3 bytes, cutting inside it keeps the previous runes only
+	require.Equal(t, "ab", string(truncateUTF8([]byte("ab日本"), 4)))
+	require.Equal(t, "ab日", string(truncateUTF8([]byte("ab日本"), 5)))
+	require.Equal(t, []byte{0xff, 0xfe}, truncateUTF8([]byte{0xff, 0xfe, 0xfd}, 2))
+}
+
+func TestAuditor_SampleByTrace(t *testing.T) {
+	a := NewAuditor(AuditConfig{SampleRate: 0.5})
+	var sampled int
+	for i := 0; i < 1000; i++ {
+		traceID := util.RandomName(16)
+		first := a.sampled(traceID)
+		for j := 0; j < 5; j++ {
+			require.Equal(t, first, a.sampled(traceID))
+		}
+		if first {
+			sampled++
+		}
+	}
+	require.InDelta(t, 500, sampled, 100)
+	require.False(t, NewAuditor(AuditConfig{}).sampled("tid"))
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	sink := NewJSONLinesAuditSink(buf)
+	require.NoError(t, sink.Write(&AuditRecord{Direction: AuditPublish, TraceID: "a"}))
+	require.NoError(t, sink.Write(&AuditRecord{Direction: AuditConsume, TraceID: "a"}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var rec AuditRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	require.Equal(t, AuditConsume, rec.Direction)
+}
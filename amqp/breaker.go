@@ -0,0 +1,491 @@
+package amqp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrSpillFull the spill file reached its size bound, the message was not buffered.
+	ErrSpillFull = errors.New("publish spill file is full")
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultMaxSpillBytes    = 256 << 20
+)
+
+// BreakerState state of a publish breaker.
+type BreakerState int32
+
+const (
+	// BreakerClosed messages are published to the broker.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen messages are spilled to disk.
+	BreakerOpen
+	// BreakerHalfOpen the spill is being replayed to probe the broker.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configuration of a publish breaker.
+type BreakerConfig struct {
+	// SpillPath append-only file buffering messages while the breaker is open, required.
+	SpillPath string
+	// MaxSpillBytes bounds the size of the spill file, default 256MB.
+	MaxSpillBytes int64
+	// FailureThreshold consecutive publish failures which trip the breaker, default 5.
+	FailureThreshold int
+	// OpenTimeout how long the breaker stays open before replaying the spill, default 30s.
+	OpenTimeout time.Duration
+}
+
+// BreakerStats a snapshot of a publish breaker.
+type BreakerStats struct {
+	State               BreakerState
+	ConsecutiveFailures int
+	// SpillDepth number of messages waiting in the spill file.
+	SpillDepth int
+	// SpillBytes size of the spill file.
+	SpillBytes int64
+	// Spilled total number of messages written to the spill file.
+	Spilled uint64
+	// Replayed total number of spilled messages published to the broker.
+	Replayed uint64
+	// Dropped total number of spilled messages dropped as they could not be decoded.
+	Dropped uint64
+}
+
+// PublishBreaker trips on consecutive publish failures and spills messages to a bounded on-disk
+// file while open. Spilled messages are replayed in order by a background drainer, messages
+// published meanwhile are spilled behind them. Replay is at least once: messages replayed
+// before the process stops without Close may be published again.
+//
+// A breaker outlives channels: after reconnecting, attach the same breaker to the new channel.
+type PublishBreaker struct {
+	cfg BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	spill    *spillFile
+	spilled  uint64
+	replayed uint64
+	dropped  uint64
+	// drainer replays the spill, nil if none is running
+	drainer *spillDrain
+	closed  bool
+}
+
+// spillDrain a running replay of the spill.
+type spillDrain struct {
+	done chan struct{}
+	err  error
+}
+
+// spillRecord a message buffered in the spill file.
+type spillRecord struct {
+	Exchange  string
+	Key       string
+	Mandatory bool
+	Immediate bool
+	Msg       Publishing
+}
+
+type publishFunc func(rec *spillRecord) error
+
+func init() {
+	// concrete types of amqp table values, so that headers keep their types in the spill file
+	gob.Register(amqp.Table{})
+	gob.Register([]interface{}{})
+	gob.Register(amqp.Decimal{})
+	gob.Register(time.Time{})
+}
+
+// NewPublishBreaker creates a publish breaker, messages left in the spill file by a previous
+// process are replayed on the first publish.
+func NewPublishBreaker(cfg BreakerConfig) (*PublishBreaker, error) {
+	if cfg.SpillPath == "" {
+		return nil, errors.New("spill path is required in publish breaker")
+	}
+	if cfg.MaxSpillBytes <= 0 {
+		cfg.MaxSpillBytes = defaultMaxSpillBytes
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultFailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = defaultOpenTimeout
+	}
+	spill, err := openSpillFile(cfg.SpillPath, cfg.MaxSpillBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &PublishBreaker{cfg: cfg, spill: spill}, nil
+}
+
+// Stats returns a snapshot of the breaker.
+func (b *PublishBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		SpillDepth:          b.spill.depth,
+		SpillBytes:          b.spill.size - b.spill.head,
+		Spilled:             b.spilled,
+		Replayed:            b.replayed,
+		Dropped:             b.dropped,
+	}
+}
+
+// Close waits for a running replay and closes the spill file, buffered messages stay on disk.
+func (b *PublishBreaker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	d := b.drainer
+	b.mu.Unlock()
+	if d != nil {
+		<-d.done
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.spill.close()
+}
+
+// publish sends rec with fn, the lock is not held while publishing to the broker.
+func (b *PublishBreaker) publish(rec *spillRecord, fn publishFunc) error {
+	// messages the broker would reject are neither published nor spilled
+	if err := rec.Msg.Headers.Validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			err := b.spillLocked(rec)
+			b.mu.Unlock()
+			return err
+		}
+		b.setStateLocked(BreakerHalfOpen)
+	}
+	if b.spill.depth > 0 || b.drainer != nil {
+		// queue behind the spilled messages to keep the order
+		err := b.spillLocked(rec)
+		b.drainLocked(fn)
+		b.mu.Unlock()
+		return err
+	}
+	b.mu.Unlock()
+
+	err := fn(rec)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+			b.tripLocked()
+			return b.spillLocked(rec)
+		}
+		return err
+	}
+	b.failures = 0
+	b.setStateLocked(BreakerClosed)
+	return nil
+}
+
+// replay publishes spilled messages regardless of the open timeout and waits for the replay.
+func (b *PublishBreaker) replay(fn publishFunc) error {
+	b.mu.Lock()
+	if b.state == BreakerOpen {
+		b.setStateLocked(BreakerHalfOpen)
+	}
+	d := b.drainLocked(fn)
+	b.mu.Unlock()
+	if d == nil {
+		return errors.New("publish breaker is closed")
+	}
+	<-d.done
+	return d.err
+}
+
+// drainLocked starts the drainer unless one is running.
+func (b *PublishBreaker) drainLocked(fn publishFunc) *spillDrain {
+	if b.drainer != nil || b.closed {
+		return b.drainer
+	}
+	d := &spillDrain{done: make(chan struct{})}
+	b.drainer = d
+	go b.drain(d, fn)
+	return d
+}
+
+// drain publishes spilled messages one by one, taking the lock only to read the next message
+// and to record the result. It closes the breaker once the spill is empty and trips it on the
+// first failure.
+func (b *PublishBreaker) drain(d *spillDrain, fn publishFunc) {
+	defer close(d.done)
+	var n int
+	for {
+		b.mu.Lock()
+		rec, size, err := b.spill.next()
+		if err == nil && rec == nil {
+			b.failures = 0
+			b.setStateLocked(BreakerClosed)
+			b.drainer = nil
+			b.mu.Unlock()
+			if n > 0 {
+				logger.Infof(context.Background(), "amqp: replayed %d spilled messages", n)
+			}
+			return
+		}
+		if err == nil {
+			err = rec.Msg.Headers.Validate()
+		}
+		if err != nil && size > 0 {
+			// the record itself is broken, retrying it would block the spill forever
+			logger.Warnf(context.Background(), "amqp: drop corrupted spill record, reason: %v", err.Error())
+			b.spill.skip(size)
+			b.dropped++
+			b.mu.Unlock()
+			continue
+		}
+		b.mu.Unlock()
+
+		if err == nil {
+			err = fn(rec)
+		}
+
+		b.mu.Lock()
+		if err != nil {
+			logger.Warnf(context.Background(), "amqp: replay spilled messages failed after %d messages, reason: %v", n, err.Error())
+			d.err = err
+			b.tripLocked()
+			// persist the progress, replayed messages are not published again after a restart
+			if cerr := b.spill.compact(); cerr != nil {
+				logger.Warnf(context.Background(), "amqp: compact spill file failed, reason: %v", cerr.Error())
+			}
+			b.drainer = nil
+			b.mu.Unlock()
+			return
+		}
+		b.spill.skip(size)
+		b.replayed++
+		n++
+		b.mu.Unlock()
+	}
+}
+
+func (b *PublishBreaker) spillLocked(rec *spillRecord) error {
+	if err := b.spill.append(rec); err != nil {
+		return err
+	}
+	b.spilled++
+	return nil
+}
+
+func (b *PublishBreaker) tripLocked() {
+	b.openedAt = time.Now()
+	b.setStateLocked(BreakerOpen)
+}
+
+func (b *PublishBreaker) setStateLocked(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	logger.Warnf(context.Background(), "amqp: publish breaker changed from %s to %s, spill depth %d", b.state, state, b.spill.depth)
+	b.state = state
+}
+
+// SetPublishBreaker guards publishing of the channel with a breaker, nil removes the breaker.
+func (ch *Channel) SetPublishBreaker(b *PublishBreaker) {
+	ch.breaker = b
+}
+
+// ReplaySpill publishes messages spilled by the breaker of the channel without waiting for the
+// open timeout, e.g. right after the connection was recovered.
+func (ch *Channel) ReplaySpill(ctx context.Context) error {
+	if ch.breaker == nil {
+		return nil
+	}
+	return ch.breaker.replay(ch.publishRecord)
+}
+
+// spillFrameHeader size of the length prefix of a record.
+const spillFrameHeader = 4
+
+// spillFile an append-only file of length prefixed gob records, consumed from head.
+type spillFile struct {
+	path string
+	max  int64
+	f    *os.File
+	// head offset of the first record not published yet
+	head  int64
+	depth int
+	size  int64
+}
+
+func openSpillFile(path string, max int64) (*spillFile, error) {
+	s := &spillFile{path: path, max: max}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	// count messages left by a previous process
+	if err := s.count(); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *spillFile) open() (err error) {
+	s.f, err = os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	return err
+}
+
+// count scans the file for complete records, a partially written trailing record is truncated.
+func (s *spillFile) count() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	s.head, s.depth, s.size = 0, 0, 0
+	var header [spillFrameHeader]byte
+	for {
+		if _, err = s.f.ReadAt(header[:], s.size); err != nil {
+			break
+		}
+		next := s.size + spillFrameHeader + int64(binary.BigEndian.Uint32(header[:]))
+		if next > info.Size() {
+			break
+		}
+		s.depth++
+		s.size = next
+	}
+	if s.size < info.Size() {
+		return s.f.Truncate(s.size)
+	}
+	return nil
+}
+
+func (s *spillFile) append(rec *spillRecord) error {
+	// a fresh encoder per record keeps every record self-describing
+	buf := bytes.NewBuffer(make([]byte, spillFrameHeader))
+	if err := gob.NewEncoder(buf).Encode(rec); err != nil {
+		return fmt.Errorf("spill record can not be encoded: %w", err)
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-spillFrameHeader))
+	if s.size+int64(len(data)) > s.max && s.head > 0 {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	if s.size+int64(len(data)) > s.max {
+		return ErrSpillFull
+	}
+	if _, err := s.f.Write(data); err != nil {
+		return err
+	}
+	s.depth++
+	s.size += int64(len(data))
+	return nil
+}
+
+// next reads the record at head, it returns nil without error if the spill is empty. A record
+// which can not be decoded is returned as an error along with its size, so it can be skipped.
+func (s *spillFile) next() (*spillRecord, int64, error) {
+	if s.head >= s.size {
+		return nil, 0, nil
+	}
+	var header [spillFrameHeader]byte
+	if _, err := s.f.ReadAt(header[:], s.head); err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := s.f.ReadAt(data, s.head+spillFrameHeader); err != nil {
+		return nil, 0, err
+	}
+	size := int64(spillFrameHeader + len(data))
+	rec := &spillRecord{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(rec); err != nil {
+		return nil, size, err
+	}
+	return rec, size, nil
+}
+
+// skip consumes the record at head, the file is truncated once every record was consumed.
+func (s *spillFile) skip(size int64) {
+	s.head += size
+	s.depth--
+	if s.head >= s.size {
+		if err := s.f.Truncate(0); err != nil {
+			logger.Warnf(context.Background(), "amqp: truncate spill file failed, reason: %v", err.Error())
+			return
+		}
+		s.head, s.depth, s.size = 0, 0, 0
+	}
+}
+
+// compact drops the consumed records before head from the file.
+func (s *spillFile) compact() error {
+	if s.head == 0 {
+		return nil
+	}
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, io.NewSectionReader(s.f, s.head, s.size-s.head)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	s.f.Close()
+	if err = s.open(); err != nil {
+		return err
+	}
+	return s.count()
+}
+
+func (s *spillFile) close() error {
+	if s.f == nil {
+		return nil
+	}
+	if err := s.compact(); err != nil {
+		logger.Warnf(context.Background(), "amqp: compact spill file failed, reason: %v", err.Error())
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,182 @@
+package amqp
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishBreaker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := BreakerConfig{
+		SpillPath:        filepath.Join(dir, "publish.spill"),
+		FailureThreshold: 2,
+		OpenTimeout:      50 * time.Millisecond,
+	}
+	b, err := NewPublishBreaker(cfg)
+	require.NoError(t, err)
+
+	var published []string
+	down := true
+	fn := func(rec *spillRecord) error {
+		if down {
+			return errors.New("broker down")
+		}
+		published = append(published, string(rec.Msg.Body))
+		return nil
+	}
+	msg := func(body string) *spillRecord {
+		return &spillRecord{Exchange: "ex", Key: "rk", Msg: Publishing{Body: []byte(body)}}
+	}
+
+	require.Error(t, b.publish(msg("1"), fn))
+	require.NoError(t, b.publish(msg("2"), fn))
+	require.NoError(t, b.publish(msg("3"), fn))
+	stats := b.Stats()
+	require.Equal(t, BreakerOpen, stats.State)
+	require.Equal(t, 2, stats.SpillDepth)
+
+	// spilled messages survive a restart
+	require.NoError(t, b.Close())
+	b, err = NewPublishBreaker(cfg)
+	require.NoError(t, err)
+	defer b.Close()
+	require.Equal(t, 2, b.Stats().SpillDepth)
+
+	// a fresh breaker queues the next publish behind the leftover messages and replays them
+	down = false
+	require.NoError(t, b.publish(msg("4"), fn))
+	require.NoError(t, b.replay(fn))
+	require.Equal(t, []string{"2", "3", "4"}, published)
+	stats = b.Stats()
+	require.Equal(t, BreakerClosed, stats.State)
+	require.Equal(t, 0, stats.SpillDepth)
+	require.Equal(t, uint64(3), stats.Replayed)
+}
+
+func TestPublishBreaker_Headers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg := BreakerConfig{SpillPath: filepath.Join(dir, "publish.spill"), FailureThreshold: 1, OpenTimeout: time.Hour}
+	b, err := NewPublishBreaker(cfg)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0).UTC()
+	headers := amqp.Table{
+		"x-death": []interface{}{amqp.Table{
+			"count":  int64(2),
+			"queue":  "orders",
+			"time":   now,
+			"reason": "rejected",
+		}},
+		"attempt":   int32(3),
+		"signature": []byte{0, 1, 2},
+		"amount":    amqp.Decimal{Scale: 2, Value: 1234},
+		"nested":    amqp.Table{"ok": true, "ratio": 0.5},
+		"none":      nil,
+	}
+	fail := func(rec *spillRecord) error { return errors.New("broker down") }
+	require.NoError(t, b.publish(&spillRecord{Exchange: "ex", Msg: Publishing{Headers: headers, Body: []byte("x")}}, fail))
+	require.Equal(t, 1, b.Stats().SpillDepth)
+
+	// headers the broker can not encode are rejected instead of spilled
+	err = b.publish(&spillRecord{Msg: Publishing{Headers: amqp.Table{"bad": map[string]interface{}{"a": 1}}}}, fail)
+	require.Error(t, err)
+	require.Equal(t, 1, b.Stats().SpillDepth)
+
+	// replayed after a restart with the types of the headers
+	require.NoError(t, b.Close())
+	b, err = NewPublishBreaker(cfg)
+	require.NoError(t, err)
+	defer b.Close()
+	var replayed []amqp.Table
+	require.NoError(t, b.replay(func(rec *spillRecord) error {
+		require.NoError(t, rec.Msg.Headers.Validate())
+		replayed = append(replayed, rec.Msg.Headers)
+		return nil
+	}))
+	require.Equal(t, []amqp.Table{headers}, replayed)
+	require.Equal(t, BreakerClosed, b.Stats().State)
+}
+
+func TestPublishBreaker_Concurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	b, err := NewPublishBreaker(BreakerConfig{SpillPath: filepath.Join(dir, "publish.spill")})
+	require.NoError(t, err)
+	defer b.Close()
+
+	// a slow broker round trip does not block other publishers
+	release := make(chan struct{})
+	slow := func(rec *spillRecord) error {
+		<-release
+		return nil
+	}
+	done := make(chan error)
+	go func() { done <- b.publish(&spillRecord{Msg: Publishing{Body: []byte("slow")}}, slow) }()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, b.publish(&spillRecord{Msg: Publishing{Body: []byte("fast")}}, func(*spillRecord) error { return nil }))
+	require.Equal(t, BreakerClosed, b.Stats().State)
+	close(release)
+	require.NoError(t, <-done)
+
+	// a corrupted record is dropped instead of blocking the replay
+	require.NoError(t, b.spill.append(&spillRecord{Msg: Publishing{Body: []byte("a")}}))
+	_, err = b.spill.f.Write([]byte{0, 0, 0, 2, 'x', 'y'})
+	require.NoError(t, err)
+	b.spill.depth++
+	b.spill.size += 6
+	require.NoError(t, b.spill.append(&spillRecord{Msg: Publishing{Body: []byte("b")}}))
+	var published []string
+	require.NoError(t, b.replay(func(rec *spillRecord) error {
+		published = append(published, string(rec.Msg.Body))
+		return nil
+	}))
+	require.Equal(t, []string{"a", "b"}, published)
+	require.Equal(t, uint64(1), b.Stats().Dropped)
+}
+
+func TestPublishBreaker_PartialReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := NewPublishBreaker(BreakerConfig{SpillPath: filepath.Join(dir, "publish.spill")})
+	require.NoError(t, err)
+	defer b.Close()
+	for _, body := range []string{"a", "b", "c"} {
+		require.NoError(t, b.spill.append(&spillRecord{Msg: Publishing{Body: []byte(body)}}))
+	}
+
+	var published []string
+	err = b.replay(func(rec *spillRecord) error {
+		if len(published) == 1 {
+			return errors.New("broker down")
+		}
+		published = append(published, string(rec.Msg.Body))
+		return nil
+	})
+	require.Error(t, err)
+	require.Equal(t, 2, b.Stats().SpillDepth)
+
+	require.NoError(t, b.replay(func(rec *spillRecord) error {
+		published = append(published, string(rec.Msg.Body))
+		return nil
+	}))
+	require.Equal(t, []string{"a", "b", "c"}, published)
+	require.Equal(t, 0, b.Stats().SpillDepth)
+
+	b.spill.max = 10
+	require.Equal(t, ErrSpillFull, b.spill.append(&spillRecord{Msg: Publishing{Body: []byte("too large")}}))
+}
@@ -0,0 +1,173 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrUnknownSchemaVersion the message was produced with a schema newer than the consumer knows.
+	ErrUnknownSchemaVersion = errors.New("unknown schema version")
+	// ErrMissingUpcaster no upcaster was registered for an old schema version.
+	ErrMissingUpcaster = errors.New("missing upcaster")
+)
+
+const (
+	// HeaderSchemaVersion header carrying the payload schema version, a missing header means version 1.
+	HeaderSchemaVersion = "x-schema-version"
+	// HeaderSchemaError header describing why a message was dead-lettered by a codec.
+	HeaderSchemaError = "x-schema-error"
+
+	contentTypeJSON = "application/json"
+)
+
+// Upcaster migrates a payload from one schema version to the next one.
+type Upcaster func(body []byte) ([]byte, error)
+
+// TypedHandler handles a message decoded by a codec.
+type TypedHandler func(ctx context.Context, ch *Channel, d *Delivery, v interface{}) error
+
+// Codec encodes json payloads with a schema version header, and decodes payloads of older
+// versions by running the registered upcasters in order up to the current version.
+type Codec struct {
+	version    int
+	upcasters  map[int]Upcaster
+	dlExchange string
+	dlKey      string
+}
+
+// NewCodec creates a codec whose current schema version is version, versions start at 1.
+func NewCodec(version int) *Codec {
+	return &Codec{
+		version:   version,
+		upcasters: make(map[int]Upcaster),
+	}
+}
+
+// Version returns the current schema version.
+func (c *Codec) Version() int {
+	return c.version
+}
+
+// RegisterUpcaster registers fn migrating payloads of version from to version from+1.
+func (c *Codec) RegisterUpcaster(from int, fn Upcaster) *Codec {
+	c.upcasters[from] = fn
+	return c
+}
+
+// SetDeadLetter republishes undecodable messages to exchange with an error header before acking
+// them. Without it they are rejected without requeue, which relies on the dead letter exchange
+// of the queue.
+func (c *Codec) SetDeadLetter(exchange, key string) *Codec {
+	c.dlExchange = exchange
+	c.dlKey = key
+	return c
+}
+
+// Marshal encodes v as a json message of the current schema version.
+func (c *Codec) Marshal(v interface{}) (Publishing, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return Publishing{}, err
+	}
+	return Publishing{
+		ContentType: contentTypeJSON,
+		Headers:     amqp.Table{HeaderSchemaVersion: int32(c.version)},
+		Body:        body,
+	}, nil
+}
+
+// Unmarshal upcasts the message body to the current schema version and decodes it into v.
+func (c *Codec) Unmarshal(d *Delivery, v interface{}) error {
+	version, err := SchemaVersionOf(d.Delivery)
+	if err != nil {
+		return err
+	}
+	if version > c.version {
+		return fmt.Errorf("%w %d, current version is %d", ErrUnknownSchemaVersion, version, c.version)
+	}
+	body := d.Body
+	for ; version < c.version; version++ {
+		upcaster, ok := c.upcasters[version]
+		if !ok {
+			return fmt.Errorf("%w from version %d", ErrMissingUpcaster, version)
+		}
+		if body, err = upcaster(body); err != nil {
+			return fmt.Errorf("upcast from version %d failed: %w", version, err)
+		}
+	}
+	return json.Unmarshal(body, v)
+}
+
+// Handler adapts a typed handler, newValue returns a pointer the payload is decoded into.
+// Messages which can not be decoded are dead-lettered and never reach the typed handler.
+func (c *Codec) Handler(newValue func() interface{}, handler TypedHandler) Handler {
+	return func(ctx context.Context, ch *Channel, d *Delivery) error {
+		v := newValue()
+		if err := c.Unmarshal(d, v); err != nil {
+			if dlErr := c.deadLetter(ctx, ch, d, err); dlErr != nil {
+				return fmt.Errorf("dead letter message failed: %v, decode error: %w", dlErr, err)
+			}
+			return err
+		}
+		return handler(ctx, ch, d, v)
+	}
+}
+
+func (c *Codec) deadLetter(ctx context.Context, ch *Channel, d *Delivery, cause error) error {
+	if c.dlExchange == "" {
+		return d.Reject(ctx, false)
+	}
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderSchemaError] = cause.Error()
+	err := ch.Publish(ctx, c.dlExchange, c.dlKey, false, false, Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err != nil {
+		// fall back to the dead letter exchange of the queue
+		return d.Reject(ctx, false)
+	}
+	return d.Ack(ctx, false)
+}
+
+// SchemaVersionOf returns the schema version of a delivery, 1 if the header is missing.
+func SchemaVersionOf(d *amqp.Delivery) (int, error) {
+	switch v := d.Headers[HeaderSchemaVersion].(type) {
+	case nil:
+		return 1, nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case int:
+		return v, nil
+	case int16:
+		return int(v), nil
+	case uint8:
+		return int(v), nil
+	case string:
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid schema version %q", v)
+		}
+		return version, nil
+	}
+	return 0, fmt.Errorf("invalid schema version type %T", d.Headers[HeaderSchemaVersion])
+}
@@ -0,0 +1,110 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAcknowledger struct {
+	acked, rejected, requeued bool
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.rejected, a.requeued = true, requeue
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.rejected, a.requeued = true, requeue
+	return nil
+}
+
+type orderEvent struct {
+	ID     string `json:"id"`
+	Amount int    `json:"amount"`
+	Chain  string `json:"chain"`
+}
+
+func newOrderCodec() *Codec {
+	return NewCodec(3).
+		// v1 -> v2: amount was renamed from value
+		RegisterUpcaster(1, func(body []byte) ([]byte, error) {
+			m := map[string]interface{}{}
+			if err := json.Unmarshal(body, &m); err != nil {
+				return nil, err
+			}
+			m["amount"] = m["value"]
+			delete(m, "value")
+			return json.Marshal(m)
+		}).
+		// v2 -> v3: chain was added
+		RegisterUpcaster(2, func(body []byte) ([]byte, error) {
+			m := map[string]interface{}{}
+			if err := json.Unmarshal(body, &m); err != nil {
+				return nil, err
+			}
+			m["chain"] = "eth"
+			return json.Marshal(m)
+		})
+}
+
+func TestCodec_Unmarshal(t *testing.T) {
+	codec := newOrderCodec()
+
+	msg, err := codec.Marshal(&orderEvent{ID: "3", Amount: 3, Chain: "bsc"})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), msg.Headers[HeaderSchemaVersion])
+
+	testCases := []struct {
+		headers  amqp.Table
+		body     string
+		expected orderEvent
+	}{
+		{headers: nil, body: `{"id":"1","value":1}`, expected: orderEvent{ID: "1", Amount: 1, Chain: "eth"}},
+		{headers: amqp.Table{HeaderSchemaVersion: int32(2)}, body: `{"id":"2","amount":2}`, expected: orderEvent{ID: "2", Amount: 2, Chain: "eth"}},
+		{headers: msg.Headers, body: string(msg.Body), expected: orderEvent{ID: "3", Amount: 3, Chain: "bsc"}},
+	}
+	for _, tc := range testCases {
+		var v orderEvent
+		err := codec.Unmarshal(&Delivery{&amqp.Delivery{Headers: tc.headers, Body: []byte(tc.body)}}, &v)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, v)
+	}
+
+	err = NewCodec(2).Unmarshal(&Delivery{&amqp.Delivery{Body: []byte(`{}`)}}, &orderEvent{})
+	require.True(t, errors.Is(err, ErrMissingUpcaster))
+}
+
+func TestCodec_HandlerRejectsFutureVersion(t *testing.T) {
+	codec := newOrderCodec()
+	var handled bool
+	handler := codec.Handler(func() interface{} { return &orderEvent{} }, func(ctx context.Context, ch *Channel, d *Delivery, v interface{}) error {
+		handled = true
+		require.Equal(t, "eth", v.(*orderEvent).Chain)
+		return nil
+	})
+
+	ack := &fakeAcknowledger{}
+	d := &Delivery{&amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{HeaderSchemaVersion: int32(4)}, Body: []byte(`{}`)}}
+	err := handler(context.Background(), nil, d)
+	require.True(t, errors.Is(err, ErrUnknownSchemaVersion))
+	require.False(t, handled)
+	require.True(t, ack.rejected)
+	require.False(t, ack.requeued)
+
+	ack = &fakeAcknowledger{}
+	d = &Delivery{&amqp.Delivery{Acknowledger: ack, Headers: amqp.Table{HeaderSchemaVersion: "2"}, Body: []byte(`{"id":"2"}`)}}
+	require.NoError(t, handler(context.Background(), nil, d))
+	require.True(t, handled)
+	require.False(t, ack.rejected)
+}
@@ -0,0 +1,183 @@
+package amqp
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/DeBankDeFi/golib/util"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// Delivery modes of a publishing.
+const (
+	Transient  = amqp.Transient
+	Persistent = amqp.Persistent
+)
+
+// PublishOption sets typed properties on a message before it is published.
+type PublishOption func(msg *Publishing)
+
+// WithPriority sets the message priority, only honored by queues declared with x-max-priority.
+func WithPriority(priority uint8) PublishOption {
+	return func(msg *Publishing) {
+		msg.Priority = priority
+	}
+}
+
+// WithTTL sets the per-message expiration, a non-positive ttl expires the message immediately
+// unless it can be delivered to a consumer at once.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(msg *Publishing) {
+		msg.Expiration = durationMillis(ttl)
+	}
+}
+
+// WithPersistent marks the message as persistent so it survives broker restarts on durable queues.
+func WithPersistent() PublishOption {
+	return func(msg *Publishing) {
+		msg.DeliveryMode = Persistent
+	}
+}
+
+// WithMessageID generates a random message id if the message does not have one.
+func WithMessageID() PublishOption {
+	return func(msg *Publishing) {
+		if msg.MessageId == "" {
+			msg.MessageId = uuid.New().String()
+		}
+	}
+}
+
+// WithTraceID carries the trace id of ctx in the trace_id header, so that consumers handle the
+// message with the same trace id. A trace id already set on the message is kept.
+func WithTraceID(ctx context.Context) PublishOption {
+	return func(msg *Publishing) {
+		if _, ok := msg.Headers[util.TraceID]; ok {
+			return
+		}
+		if traceID := util.GetTraceIDFromContext(ctx); traceID != "" {
+			if msg.Headers == nil {
+				msg.Headers = amqp.Table{}
+			}
+			msg.Headers[util.TraceID] = traceID
+		}
+	}
+}
+
+// WithTimestamp sets the message timestamp to now if the message does not have one.
+func WithTimestamp() PublishOption {
+	return func(msg *Publishing) {
+		if msg.Timestamp.IsZero() {
+			msg.Timestamp = time.Now()
+		}
+	}
+}
+
+// WithHeader sets a single message header.
+func WithHeader(key string, value interface{}) PublishOption {
+	return func(msg *Publishing) {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		msg.Headers[key] = value
+	}
+}
+
+// QueueType the x-queue-type of a queue.
+type QueueType string
+
+const (
+	QueueTypeClassic QueueType = "classic"
+	QueueTypeQuorum  QueueType = "quorum"
+	QueueTypeStream  QueueType = "stream"
+)
+
+// Queue argument keys understood by rabbitmq.
+const (
+	ArgMaxPriority          = "x-max-priority"
+	ArgMessageTTL           = "x-message-ttl"
+	ArgExpires              = "x-expires"
+	ArgMaxLength            = "x-max-length"
+	ArgMaxLengthBytes       = "x-max-length-bytes"
+	ArgDeadLetterExchange   = "x-dead-letter-exchange"
+	ArgDeadLetterRoutingKey = "x-dead-letter-routing-key"
+	ArgQueueType            = "x-queue-type"
+)
+
+// QueueArgs args which declaring a queue.
+type QueueArgs struct {
+	// Queue name, if empty rabbitmq will generate a random name.
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Nowait     bool
+
+	// MaxPriority enables priority queue with the max priority, 0 means disabled.
+	MaxPriority uint8
+	// MessageTTL discards messages older than ttl, 0 means no ttl.
+	MessageTTL time.Duration
+	// Expires deletes the queue after being unused for the duration, 0 means never.
+	Expires time.Duration
+	// MaxLength max number of ready messages, 0 means unlimited.
+	MaxLength int64
+	// MaxLengthBytes max total body size of ready messages, 0 means unlimited.
+	MaxLengthBytes int64
+	// DeadLetterExchange exchange to which rejected or expired messages are republished, optional.
+	DeadLetterExchange string
+	// DeadLetterRoutingKey replaces the routing key of dead-lettered messages, optional.
+	DeadLetterRoutingKey string
+	// Type queue type, rabbitmq defaults to classic.
+	Type QueueType
+
+	// Args extra raw arguments, the typed fields above take precedence.
+	Args amqp.Table
+}
+
+// Table returns the declaration arguments in rabbitmq wire format.
+func (a *QueueArgs) Table() amqp.Table {
+	table := amqp.Table{}
+	for k, v := range a.Args {
+		table[k] = v
+	}
+	if a.MaxPriority > 0 {
+		table[ArgMaxPriority] = int32(a.MaxPriority)
+	}
+	if a.MessageTTL > 0 {
+		table[ArgMessageTTL] = a.MessageTTL.Milliseconds()
+	}
+	if a.Expires > 0 {
+		table[ArgExpires] = a.Expires.Milliseconds()
+	}
+	if a.MaxLength > 0 {
+		table[ArgMaxLength] = a.MaxLength
+	}
+	if a.MaxLengthBytes > 0 {
+		table[ArgMaxLengthBytes] = a.MaxLengthBytes
+	}
+	if a.DeadLetterExchange != "" {
+		table[ArgDeadLetterExchange] = a.DeadLetterExchange
+	}
+	if a.DeadLetterRoutingKey != "" {
+		table[ArgDeadLetterRoutingKey] = a.DeadLetterRoutingKey
+	}
+	if a.Type != "" {
+		table[ArgQueueType] = string(a.Type)
+	}
+	return table
+}
+
+// DeclareQueue declare a queue with typed arguments.
+func (ch *Channel) DeclareQueue(args *QueueArgs) (amqp.Queue, error) {
+	return ch.Channel.QueueDeclare(args.Name, args.Durable, args.AutoDelete, args.Exclusive, args.Nowait, args.Table())
+}
+
+func durationMillis(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
@@ -0,0 +1,79 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/util"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishOptions(t *testing.T) {
+	msg := Publishing{MessageId: "fixed"}
+	for _, opt := range []PublishOption{
+		WithPriority(5),
+		WithTTL(1500 * time.Millisecond),
+		WithPersistent(),
+		WithMessageID(),
+		WithTimestamp(),
+		WithHeader("foo", "bar"),
+	} {
+		opt(&msg)
+	}
+	require.Equal(t, uint8(5), msg.Priority)
+	require.Equal(t, "1500", msg.Expiration)
+	require.Equal(t, Persistent, msg.DeliveryMode)
+	require.Equal(t, "fixed", msg.MessageId)
+	require.False(t, msg.Timestamp.IsZero())
+	require.Equal(t, "bar", msg.Headers["foo"])
+
+	msg = Publishing{}
+	WithMessageID()(&msg)
+	WithTTL(-time.Second)(&msg)
+	require.NotEmpty(t, msg.MessageId)
+	require.Equal(t, "0", msg.Expiration)
+
+	// the trace id of ctx is only sent when asked for, and does not replace an explicit one
+	ctx := util.SetTraceIDToContext(context.Background(), "tid-1")
+	msg = Publishing{}
+	WithTraceID(ctx)(&msg)
+	require.Equal(t, "tid-1", msg.Headers[util.TraceID])
+	msg = Publishing{Headers: amqp.Table{util.TraceID: "tid-0"}}
+	WithTraceID(ctx)(&msg)
+	require.Equal(t, "tid-0", msg.Headers[util.TraceID])
+	msg = Publishing{}
+	WithTraceID(context.Background())(&msg)
+	require.Nil(t, msg.Headers)
+}
+
+func TestQueueArgs_Table(t *testing.T) {
+	args := &QueueArgs{
+		Name:               "q",
+		MaxPriority:        10,
+		MessageTTL:         time.Minute,
+		Expires:            time.Hour,
+		MaxLength:          100,
+		DeadLetterExchange: "dlx",
+		Type:               QueueTypeQuorum,
+		Args: amqp.Table{
+			ArgMaxPriority: int32(1),
+			"x-custom":     "v",
+		},
+	}
+	table := args.Table()
+	require.NoError(t, table.Validate())
+	require.Equal(t, amqp.Table{
+		ArgMaxPriority:        int32(10),
+		ArgMessageTTL:         int64(60000),
+		ArgExpires:            int64(3600000),
+		ArgMaxLength:          int64(100),
+		ArgDeadLetterExchange: "dlx",
+		ArgQueueType:          "quorum",
+		"x-custom":            "v",
+	}, table)
+
+	require.Empty(t, (&QueueArgs{}).Table())
+}
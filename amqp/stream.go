@@ -0,0 +1,309 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrStreamAutoAck stream queues require manual acknowledgement.
+	ErrStreamAutoAck = errors.New("stream queue can not be consumed with auto ack")
+	// ErrStreamConsumerName a named consumer is required to track offsets.
+	ErrStreamConsumerName = errors.New("stream consumer name is required with an offset store")
+)
+
+const (
+	// ArgStreamOffset consume argument selecting where a stream consumer starts.
+	ArgStreamOffset = "x-stream-offset"
+	// HeaderStreamOffset header carrying the offset of a message delivered from a stream.
+	HeaderStreamOffset = "x-stream-offset"
+
+	defaultStreamPrefetch = 100
+)
+
+// StreamOffset specifies where a stream consumer starts reading.
+type StreamOffset struct {
+	spec interface{}
+}
+
+var (
+	// StreamOffsetFirst starts from the first message available in the stream.
+	StreamOffsetFirst = StreamOffset{spec: "first"}
+	// StreamOffsetLast starts from the last written chunk of the stream.
+	StreamOffsetLast = StreamOffset{spec: "last"}
+	// StreamOffsetNext only delivers messages published after the consumer starts, rabbitmq default.
+	StreamOffsetNext = StreamOffset{spec: "next"}
+)
+
+// StreamOffsetAt starts from a specific numeric offset.
+func StreamOffsetAt(offset int64) StreamOffset {
+	return StreamOffset{spec: offset}
+}
+
+// StreamOffsetFrom starts from the first chunk written at or after t.
+func StreamOffsetFrom(t time.Time) StreamOffset {
+	return StreamOffset{spec: t}
+}
+
+// IsZero reports whether the offset is unspecified.
+func (o StreamOffset) IsZero() bool {
+	return o.spec == nil
+}
+
+func (o StreamOffset) String() string {
+	if o.spec == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", o.spec)
+}
+
+// OffsetStore persists the last processed offset of a named stream consumer.
+type OffsetStore interface {
+	// Load returns the last saved offset, ok is false if nothing was saved yet.
+	Load(ctx context.Context, stream, consumer string) (offset int64, ok bool, err error)
+	// Save records offset as the last processed offset.
+	Save(ctx context.Context, stream, consumer string, offset int64) error
+}
+
+// StreamArgs args which consuming from a stream queue.
+type StreamArgs struct {
+	// Offset where to start if the store has no saved offset, defaults to next.
+	Offset StreamOffset
+	// Store tracks processed offsets so the consumer resumes after a restart, optional.
+	Store OffsetStore
+	// Name identifies the consumer in the offset store, required with Store.
+	Name string
+	// Prefetch unacknowledged messages limit, required by stream queues, default 100.
+	Prefetch int
+	// CommitEvery saves the offset every n processed messages, default 1.
+	CommitEvery int
+	// SkipFailed saves offsets past messages whose handler failed, so that they are not
+	// delivered again on resume. By default the offset stops before the first failed message.
+	SkipFailed bool
+}
+
+// streamArgs resolves stream arguments into the consume table.
+func (ch *Channel) streamArgs(ctx context.Context, args *DeliveryArgs) (amqp.Table, error) {
+	return resolveStreamArgs(ctx, args, ch.Channel.Qos)
+}
+
+// resolveStreamArgs validates the stream arguments, sets the prefetch with qos and returns the
+// consume table starting after the saved offset if any.
+func resolveStreamArgs(ctx context.Context, args *DeliveryArgs, qos func(prefetchCount, prefetchSize int, global bool) error) (amqp.Table, error) {
+	stream := args.Stream
+	if args.AutoAck {
+		return nil, ErrStreamAutoAck
+	}
+	if stream.Store != nil && stream.Name == "" {
+		return nil, ErrStreamConsumerName
+	}
+	prefetch := stream.Prefetch
+	if prefetch <= 0 {
+		prefetch = defaultStreamPrefetch
+	}
+	if err := qos(prefetch, 0, false); err != nil {
+		return nil, err
+	}
+
+	table := amqp.Table{}
+	for k, v := range args.Args {
+		table[k] = v
+	}
+	offset := stream.Offset
+	if stream.Store != nil {
+		saved, ok, err := stream.Store.Load(ctx, args.Queue, stream.Name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			offset = StreamOffsetAt(saved + 1)
+		}
+	}
+	if !offset.IsZero() {
+		table[ArgStreamOffset] = offset.spec
+	}
+	return table, nil
+}
+
+// ConsumeStream consume message from a stream queue in block mode, starting at the saved offset
+// of the consumer if any. Messages are handled sequentially and their offsets are saved to the
+// offset store. Offsets are not saved past the first message whose handler failed, so that it
+// is delivered again on resume, unless StreamArgs.SkipFailed is set.
+func (ch *Channel) ConsumeStream(ctx context.Context, args *DeliveryArgs, handler Handler) error {
+	if args.Stream == nil {
+		args.Stream = &StreamArgs{}
+	}
+	dc, err := ch.delivery(ctx, args)
+	if err != nil {
+		return err
+	}
+	return ch.consumeStream(ctx, args, handler, dc)
+}
+
+func (ch *Channel) consumeStream(ctx context.Context, args *DeliveryArgs, handler Handler, dc <-chan amqp.Delivery) error {
+	stream := args.Stream
+	commitEvery := stream.CommitEvery
+	if commitEvery <= 0 {
+		commitEvery = 1
+	}
+	var (
+		pending   int
+		lastSaved int64 = -1
+		last      int64 = -1
+		// failed stops advancing the offset at the first failed message
+		failed bool
+	)
+	commit := func() {
+		if stream.Store == nil || last < 0 || last == lastSaved {
+			return
+		}
+		// use a fresh context, the consumer context may already be done while flushing
+		if err := stream.Store.Save(context.Background(), args.Queue, stream.Name, last); err != nil {
+			logger.Warnf(ctx, "amqp: save offset %d of %s stream failed, reason: %v", last, args.Queue, err.Error())
+			return
+		}
+		lastSaved = last
+		pending = 0
+	}
+	defer commit()
+
+	logger.Infof(ctx, "amqp: start the consumer of %s stream", args.Queue)
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof(ctx, "amqp: stop the consumer of %s stream", args.Queue)
+			return nil
+		case d, ok := <-dc:
+			if !ok {
+				logger.Warnf(ctx, "amqp: the deliver channel of %s stream closed", args.Queue)
+				return ErrDeliveryChannelClosed
+			}
+			if err := ch.consume(args.Queue, handler, &Delivery{&d}); err != nil {
+				logger.Warnf(ctx, "amqp: execute handler with stream %s failed, reason: %v", args.Queue, err.Error())
+				if !stream.SkipFailed && !failed {
+					failed = true
+					commit()
+				}
+				continue
+			}
+			if offset, ok := StreamOffsetOf(&d); ok && !failed {
+				last = offset
+				if pending++; pending >= commitEvery {
+					commit()
+				}
+			}
+		}
+	}
+}
+
+// StreamOffsetOf returns the stream offset of a delivery.
+func StreamOffsetOf(d *amqp.Delivery) (int64, bool) {
+	switch v := d.Headers[HeaderStreamOffset].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// MemoryOffsetStore keeps offsets in memory, mostly useful in tests.
+type MemoryOffsetStore struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+// NewMemoryOffsetStore creates a new in-memory offset store.
+func NewMemoryOffsetStore() *MemoryOffsetStore {
+	return &MemoryOffsetStore{offsets: make(map[string]int64)}
+}
+
+// Load ...
+func (s *MemoryOffsetStore) Load(ctx context.Context, stream, consumer string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offset, ok := s.offsets[stream+"/"+consumer]
+	return offset, ok, nil
+}
+
+// Save ...
+func (s *MemoryOffsetStore) Save(ctx context.Context, stream, consumer string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offsets[stream+"/"+consumer] = offset
+	return nil
+}
+
+// FileOffsetStore keeps each offset in a small file under a directory.
+type FileOffsetStore struct {
+	dir string
+}
+
+// NewFileOffsetStore creates a new file offset store, the directory is created if missing.
+func NewFileOffsetStore(dir string) (*FileOffsetStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileOffsetStore{dir: dir}, nil
+}
+
+func (s *FileOffsetStore) path(stream, consumer string) string {
+	return filepath.Join(s.dir, escapeFileName(stream)+"."+escapeFileName(consumer)+".offset")
+}
+
+// escapeFileName escapes every byte except letters, digits, '_' and '-' as %XX, names never
+// collide and never contain the '.' separating stream and consumer.
+func escapeFileName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Load ...
+func (s *FileOffsetStore) Load(ctx context.Context, stream, consumer string) (int64, bool, error) {
+	data, err := ioutil.ReadFile(s.path(stream, consumer))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, true, nil
+}
+
+// Save writes the offset atomically by renaming a temporary file.
+func (s *FileOffsetStore) Save(ctx context.Context, stream, consumer string, offset int64) error {
+	path := s.path(stream, consumer)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,129 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offsets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fileStore, err := NewFileOffsetStore(dir)
+	require.NoError(t, err)
+
+	for _, store := range []OffsetStore{NewMemoryOffsetStore(), fileStore} {
+		ctx := context.Background()
+		_, ok, err := store.Load(ctx, "events", "replayer/1")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, store.Save(ctx, "events", "replayer/1", 42))
+		require.NoError(t, store.Save(ctx, "events", "other", 7))
+		offset, ok, err := store.Load(ctx, "events", "replayer/1")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, int64(42), offset)
+
+		// names which only differ in escaped characters do not share an offset
+		require.NoError(t, store.Save(ctx, "events", "replayer_1", 1))
+		require.NoError(t, store.Save(ctx, "events.a", "b", 2))
+		require.NoError(t, store.Save(ctx, "events", "a.b", 3))
+		for consumer, want := range map[string]int64{"replayer/1": 42, "replayer_1": 1} {
+			offset, _, err := store.Load(ctx, "events", consumer)
+			require.NoError(t, err)
+			require.Equal(t, want, offset)
+		}
+		offset, _, err = store.Load(ctx, "events.a", "b")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), offset)
+	}
+}
+
+func TestResolveStreamArgs(t *testing.T) {
+	ctx := context.Background()
+	prefetch := 0
+	qos := func(prefetchCount, prefetchSize int, global bool) error {
+		prefetch = prefetchCount
+		return nil
+	}
+
+	_, err := resolveStreamArgs(ctx, &DeliveryArgs{Queue: "events", AutoAck: true, Stream: &StreamArgs{}}, qos)
+	require.Equal(t, ErrStreamAutoAck, err)
+	require.Zero(t, prefetch)
+	store := NewMemoryOffsetStore()
+	_, err = resolveStreamArgs(ctx, &DeliveryArgs{Queue: "events", Stream: &StreamArgs{Store: store}}, qos)
+	require.Equal(t, ErrStreamConsumerName, err)
+
+	// without a saved offset the configured one is used
+	table, err := resolveStreamArgs(ctx, &DeliveryArgs{
+		Queue:  "events",
+		Args:   amqp.Table{"x-priority": 1},
+		Stream: &StreamArgs{Store: store, Name: "replayer", Offset: StreamOffsetFirst},
+	}, qos)
+	require.NoError(t, err)
+	require.Equal(t, defaultStreamPrefetch, prefetch)
+	require.Equal(t, amqp.Table{"x-priority": 1, ArgStreamOffset: "first"}, table)
+
+	// resume after the saved offset
+	require.NoError(t, store.Save(ctx, "events", "replayer", 41))
+	table, err = resolveStreamArgs(ctx, &DeliveryArgs{
+		Queue:  "events",
+		Stream: &StreamArgs{Store: store, Name: "replayer", Offset: StreamOffsetFirst, Prefetch: 10},
+	}, qos)
+	require.NoError(t, err)
+	require.Equal(t, 10, prefetch)
+	require.Equal(t, int64(42), table[ArgStreamOffset])
+
+	qosErr := errors.New("channel closed")
+	_, err = resolveStreamArgs(ctx, &DeliveryArgs{Queue: "events", Stream: &StreamArgs{}}, func(int, int, bool) error { return qosErr })
+	require.Equal(t, qosErr, err)
+}
+
+func TestConsumeStream_Failed(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		dc := make(chan amqp.Delivery, 5)
+		for i := int64(1); i <= 5; i++ {
+			dc <- amqp.Delivery{Headers: amqp.Table{HeaderStreamOffset: i}}
+		}
+		close(dc)
+		store := NewMemoryOffsetStore()
+		args := &DeliveryArgs{Queue: "events", Stream: &StreamArgs{Store: store, Name: "replayer", SkipFailed: skip}}
+		var handled []int64
+		err := (&Channel{}).consumeStream(context.Background(), args, func(ctx context.Context, ch *Channel, d *Delivery) error {
+			offset, _ := StreamOffsetOf(d.Delivery)
+			handled = append(handled, offset)
+			if offset == 3 {
+				return errors.New("failed")
+			}
+			return nil
+		}, dc)
+		require.Equal(t, ErrDeliveryChannelClosed, err)
+		require.Equal(t, []int64{1, 2, 3, 4, 5}, handled)
+
+		saved, _, err := store.Load(context.Background(), "events", "replayer")
+		require.NoError(t, err)
+		if skip {
+			require.Equal(t, int64(5), saved)
+		} else {
+			// resuming delivers the failed message again
+			require.Equal(t, int64(2), saved)
+		}
+	}
+}
+
+func TestStreamOffsetOf(t *testing.T) {
+	offset, ok := StreamOffsetOf(&amqp.Delivery{Headers: amqp.Table{HeaderStreamOffset: int64(12)}})
+	require.True(t, ok)
+	require.Equal(t, int64(12), offset)
+
+	_, ok = StreamOffsetOf(&amqp.Delivery{})
+	require.False(t, ok)
+}
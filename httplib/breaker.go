@@ -0,0 +1,255 @@
+package httplib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrIDCircuitOpen the breaker of the host is open and the request was not sent.
+const ErrIDCircuitOpen = "HTTP_CIRCUIT_OPEN"
+
+// BreakerState state of the circuit breaker of a host.
+type BreakerState int32
+
+const (
+	// BreakerClosed requests are sent.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen requests fail fast without being sent.
+	BreakerOpen
+	// BreakerHalfOpen a limited number of probe requests are sent.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configuration of the per-host circuit breakers of an HTTPClient.
+type BreakerConfig struct {
+	// WindowSize number of recent calls the rates are computed over, default 20.
+	WindowSize int
+	// MinRequests calls in the window before the breaker may trip, default 10.
+	MinRequests int
+	// FailureRateThreshold failure rate which trips the breaker, default 0.5.
+	FailureRateThreshold float64
+	// SlowCallDuration calls slower than it count as slow, 0 disables latency tracking.
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold slow call rate which trips the breaker, default 0.5.
+	SlowCallRateThreshold float64
+	// OpenTimeout how long the breaker stays open before probing, default 30s.
+	OpenTimeout time.Duration
+	// HalfOpenMaxCalls probe calls which must succeed to close the breaker, default 1.
+	HalfOpenMaxCalls int
+	// IsFailure reports whether a call failed, defaults to errors and 5xx responses.
+	IsFailure func(rsp *http.Response, err error) bool
+	// OnStateChange is called after the breaker of a host changed state, optional.
+	OnStateChange func(host string, from, to BreakerState)
+}
+
+func (cfg BreakerConfig) withDefaults() BreakerConfig {
+	if cfg.WindowSize <= 0 {
+		cfg.WindowSize = 20
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.MinRequests > cfg.WindowSize {
+		cfg.MinRequests = cfg.WindowSize
+	}
+	if cfg.FailureRateThreshold <= 0 {
+		cfg.FailureRateThreshold = 0.5
+	}
+	if cfg.SlowCallRateThreshold <= 0 {
+		cfg.SlowCallRateThreshold = 0.5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 30 * time.Second
+	}
+	if cfg.HalfOpenMaxCalls <= 0 {
+		cfg.HalfOpenMaxCalls = 1
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = defaultIsFailure
+	}
+	return cfg
+}
+
+func defaultIsFailure(rsp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return rsp.StatusCode >= http.StatusInternalServerError
+}
+
+// SetBreaker 为每个host启用熔断
+func (c *HTTPClient) SetBreaker(cfg BreakerConfig) *HTTPClient {
+	c.breakers = &breakerGroup{
+		cfg:   cfg.withDefaults(),
+		hosts: make(map[string]*hostBreaker),
+	}
+	return c
+}
+
+// BreakerState returns the breaker state of a host, closed if breakers are not enabled.
+func (c *HTTPClient) BreakerState(host string) BreakerState {
+	if c.breakers == nil {
+		return BreakerClosed
+	}
+	return c.breakers.get(host).currentState()
+}
+
+type breakerGroup struct {
+	cfg   BreakerConfig
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+func (g *breakerGroup) get(host string) *hostBreaker {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.hosts[host]
+	if !ok {
+		b = &hostBreaker{
+			host:    host,
+			cfg:     &g.cfg,
+			results: make([]callResult, g.cfg.WindowSize),
+		}
+		g.hosts[host] = b
+	}
+	return b
+}
+
+type callResult struct {
+	failure bool
+	slow    bool
+}
+
+type hostBreaker struct {
+	host string
+	cfg  *BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	openedAt time.Time
+	// sliding window of recent calls in closed state
+	results []callResult
+	next    int
+	count   int
+	// probes in half-open state
+	probes    int
+	successes int
+}
+
+func (b *hostBreaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a call may be sent.
+func (b *hostBreaker) allow() bool {
+	b.mu.Lock()
+	from := b.state
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		b.setStateLocked(BreakerHalfOpen)
+	}
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenMaxCalls {
+			allowed = false
+		} else {
+			b.probes++
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return allowed
+}
+
+// record records the outcome of an allowed call.
+func (b *hostBreaker) record(rsp *http.Response, err error, latency time.Duration) {
+	// canceled calls tell nothing about the health of the host
+	neutral := errors.Is(err, context.Canceled)
+	result := callResult{
+		failure: b.cfg.IsFailure(rsp, err),
+		slow:    b.cfg.SlowCallDuration > 0 && latency > b.cfg.SlowCallDuration,
+	}
+
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if neutral {
+			break
+		}
+		if result.failure || result.slow {
+			b.tripLocked()
+		} else if b.successes++; b.successes >= b.cfg.HalfOpenMaxCalls {
+			b.setStateLocked(BreakerClosed)
+		}
+	case BreakerClosed:
+		if neutral {
+			break
+		}
+		b.results[b.next] = result
+		b.next = (b.next + 1) % len(b.results)
+		if b.count < len(b.results) {
+			b.count++
+		}
+		if b.count >= b.cfg.MinRequests {
+			var failures, slows int
+			for _, r := range b.results[:b.count] {
+				if r.failure {
+					failures++
+				}
+				if r.slow {
+					slows++
+				}
+			}
+			if float64(failures)/float64(b.count) >= b.cfg.FailureRateThreshold ||
+				(b.cfg.SlowCallDuration > 0 && float64(slows)/float64(b.count) >= b.cfg.SlowCallRateThreshold) {
+				b.tripLocked()
+			}
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *hostBreaker) tripLocked() {
+	b.openedAt = time.Now()
+	b.setStateLocked(BreakerOpen)
+}
+
+func (b *hostBreaker) setStateLocked(state BreakerState) {
+	b.state = state
+	b.probes, b.successes = 0, 0
+	if state == BreakerClosed {
+		b.next, b.count = 0, 0
+	}
+}
+
+func (b *hostBreaker) notify(from, to BreakerState) {
+	if from != to && b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(b.host, from, to)
+	}
+}
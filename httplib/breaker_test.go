@@ -0,0 +1,82 @@
+package httplib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestBreaker(t *testing.T) {
+	var healthy int32
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	var mu sync.Mutex
+	var transitions []string
+	client := httplib.NewHTTPClient().SetBreaker(httplib.BreakerConfig{
+		WindowSize:  4,
+		MinRequests: 4,
+		OpenTimeout: 50 * time.Millisecond,
+		OnStateChange: func(host string, from, to httplib.BreakerState) {
+			require.Equal(t, u.Host, host)
+			mu.Lock()
+			transitions = append(transitions, from.String()+"->"+to.String())
+			mu.Unlock()
+		},
+	})
+	get := func() error {
+		return client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL})
+	}
+
+	for i := 0; i < 4; i++ {
+		require.Error(t, get())
+	}
+	require.Equal(t, httplib.BreakerOpen, client.BreakerState(u.Host))
+
+	err := get()
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDCircuitOpen, err.(*syserror.SysError).ID)
+	require.Equal(t, codes.Unavailable, err.(*syserror.SysError).Code)
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&healthy, 1)
+	require.NoError(t, get())
+	require.Equal(t, httplib.BreakerClosed, client.BreakerState(u.Host))
+	require.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, transitions)
+}
+
+func TestBreaker_SlowCalls(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	client := httplib.NewHTTPClient().SetBreaker(httplib.BreakerConfig{
+		WindowSize:       2,
+		SlowCallDuration: 5 * time.Millisecond,
+	})
+	for i := 0; i < 2; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}))
+	}
+	require.Equal(t, httplib.BreakerOpen, client.BreakerState(u.Host))
+}
@@ -0,0 +1,170 @@
+package httplib
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/DeBankDeFi/golib/util"
+)
+
+// Response the outcome of a request sent by Send.
+type Response[T any] struct {
+	StatusCode int
+	Header     http.Header
+	// Value the decoded body, the raw body if T is []byte.
+	Value T
+	// Duration from sending the first attempt until the body was decoded.
+	Duration time.Duration
+	// Attempts made by the retry policy and the endpoint pool.
+	Attempts int
+}
+
+// Request a request built fluently, send it with Send or Request.Do.
+//
+//	rsp, err := httplib.Send[Balance](ctx, client.NewRequest(http.MethodGet, "/v1/balance").
+//		Query("addr", addr).
+//		Expect(http.StatusOK))
+type Request struct {
+	client *HTTPClient
+	method string
+	args   RequestArgs
+}
+
+// NewRequest 创建一个请求构建器, url可以是绝对地址, 或者 EndpointPool 的相对路径
+func (c *HTTPClient) NewRequest(method, url string) *Request {
+	return &Request{
+		client: c,
+		method: method,
+		args:   RequestArgs{URL: url},
+	}
+}
+
+// TraceID sets the trace id, the trace id of the context is used if unset.
+func (r *Request) TraceID(traceID string) *Request {
+	r.args.TraceID = traceID
+	return r
+}
+
+// Host overrides the Host header.
+func (r *Request) Host(host string) *Request {
+	r.args.Host = host
+	return r
+}
+
+// Query adds a url query parameter.
+func (r *Request) Query(key, value string) *Request {
+	if r.args.Params == nil {
+		r.args.Params = make(map[string]string)
+	}
+	r.args.Params[key] = value
+	return r
+}
+
+// Header sets a request header.
+func (r *Request) Header(key, value string) *Request {
+	if r.args.Headers == nil {
+		r.args.Headers = make(map[string]string)
+	}
+	r.args.Headers[key] = value
+	return r
+}
+
+// Body sets the request body, see RequestArgs.Body for the supported kinds.
+func (r *Request) Body(body interface{}) *Request {
+	r.args.Body = body
+	return r
+}
+
+// Protobuf encodes the body and decodes the result as protobuf json.
+func (r *Request) Protobuf() *Request {
+	r.args.ProtobufType = true
+	return r
+}
+
+// Expect sets the expected status codes, 200 by default.
+func (r *Request) Expect(codes ...int) *Request {
+	r.args.ExpectedStatusCode = codes
+	return r
+}
+
+// BasicAuth sets basic authentication.
+func (r *Request) BasicAuth(username, password string) *Request {
+	r.args.BasicAuth = &BasicAuth{Username: username, Password: password}
+	return r
+}
+
+// Idempotent allows the retry policy to retry a non-idempotent method.
+func (r *Request) Idempotent() *Request {
+	r.args.Idempotent = true
+	return r
+}
+
+// Args returns the arguments built so far, e.g. to send them with the HTTPClient methods.
+func (r *Request) Args() *RequestArgs {
+	return &r.args
+}
+
+// Do sends the request and returns the raw body.
+func (r *Request) Do(ctx context.Context) (*Response[[]byte], error) {
+	return Send[[]byte](ctx, r)
+}
+
+// Send sends the request and decodes the body into a T, as protobuf json if the request is
+// marked so and as json otherwise. The response is returned along with status code and
+// decode errors, it is nil if no response was received.
+func Send[T any](ctx context.Context, r *Request) (*Response[T], error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	args := r.args
+	if args.TraceID == "" {
+		args.TraceID = util.GetTraceIDFromContext(ctx)
+	}
+
+	start := time.Now()
+	req, rsp, attempts, err := r.client.send(ctx, r.method, &args, false)
+	if err != nil {
+		return nil, err
+	}
+	res := &Response[T]{
+		StatusCode: rsp.StatusCode,
+		Header:     rsp.Header,
+		Attempts:   attempts,
+	}
+	var raw *bytes.Buffer
+	switch v := interface{}(&res.Value).(type) {
+	case *[]byte:
+		raw = bytes.NewBuffer(nil)
+		args.BytesResult = raw
+	default:
+		args.JSONResult = v
+		if args.ProtobufType {
+			// protobuf messages are pointers, decode into a new message
+			if t := reflect.TypeOf(res.Value); t != nil && t.Kind() == reflect.Ptr {
+				reflect.ValueOf(&res.Value).Elem().Set(reflect.New(t.Elem()))
+				args.JSONResult = res.Value
+			}
+		}
+	}
+	err = r.client.handleResponse(req, rsp, &args)
+	if raw != nil {
+		res.Value = interface{}(raw.Bytes()).(T)
+	}
+	res.Duration = time.Since(start)
+	return res, withAttempts(err, attempts)
+}
+
+// GetJSON sends a GET request to url and decodes the json body into a T.
+func GetJSON[T any](ctx context.Context, c *HTTPClient, url string) (*Response[T], error) {
+	return Send[T](ctx, c.NewRequest(http.MethodGet, url))
+}
+
+// PostJSON posts body as json to url and decodes the json body into a T.
+func PostJSON[T any](ctx context.Context, c *HTTPClient, url string, body interface{}) (*Response[T], error) {
+	return Send[T](ctx, c.NewRequest(http.MethodPost, url).
+		Header("Content-Type", "application/json").
+		Body(body))
+}
@@ -0,0 +1,86 @@
+package httplib_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/require"
+)
+
+type balance struct {
+	Addr   string  `json:"addr"`
+	Amount float64 `json:"amount"`
+}
+
+func TestRequestBuilder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/balance":
+			require.Equal(t, "key", r.Header.Get("X-Api-Key"))
+			w.Header().Set("X-Block", "100")
+			json.NewEncoder(w).Encode(balance{Addr: r.URL.Query().Get("addr"), Amount: 1.5})
+		case "/echo":
+			body, _ := ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write(body)
+		case "/name":
+			w.Write([]byte(`"debank"`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient()
+	ctx := context.Background()
+
+	rsp, err := httplib.Send[balance](ctx, client.NewRequest(http.MethodGet, srv.URL+"/balance").
+		TraceID("fakeID").
+		Query("addr", "0x01").
+		Header("X-Api-Key", "key"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Equal(t, "100", rsp.Header.Get("X-Block"))
+	require.Equal(t, balance{Addr: "0x01", Amount: 1.5}, rsp.Value)
+	require.Equal(t, 1, rsp.Attempts)
+	require.True(t, rsp.Duration > 0)
+
+	raw, err := client.NewRequest(http.MethodPost, srv.URL+"/echo").
+		Body(map[string]int{"a": 1}).
+		Expect(http.StatusCreated).
+		Do(ctx)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, raw.StatusCode)
+	require.JSONEq(t, `{"a":1}`, string(raw.Value))
+
+	posted, err := httplib.PostJSON[map[string]int](ctx, client, srv.URL+"/echo", map[string]int{"b": 2})
+	require.Error(t, err)
+	require.Equal(t, http.StatusCreated, posted.StatusCode)
+	require.Equal(t, "HTTP_STATUS_CODE_NOT_OK", err.(*syserror.SysError).ID)
+
+	missing, err := httplib.GetJSON[balance](ctx, client, srv.URL+"/missing")
+	require.Error(t, err)
+	require.Equal(t, http.StatusNotFound, missing.StatusCode)
+
+	name, err := httplib.Send[*wrappers.StringValue](ctx, client.NewRequest(http.MethodGet, srv.URL+"/name").Protobuf())
+	require.NoError(t, err)
+	require.Equal(t, "debank", name.Value.GetValue())
+
+	// the built arguments work with the existing methods
+	var result balance
+	args := client.NewRequest(http.MethodGet, srv.URL+"/balance").
+		TraceID("fakeID").
+		Query("addr", "0x02").
+		Header("X-Api-Key", "key").
+		Args()
+	args.JSONResult = &result
+	require.NoError(t, client.Get(ctx, args))
+	require.Equal(t, "0x02", result.Addr)
+}
@@ -0,0 +1,438 @@
+package httplib
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatusHeader response header telling how the cache served a response.
+const CacheStatusHeader = "X-Httplib-Cache"
+
+// Values of CacheStatusHeader.
+const (
+	CacheMiss        = "MISS"
+	CacheHit         = "HIT"
+	CacheRevalidated = "REVALIDATED"
+)
+
+// CacheStore stores serialized responses of the response cache.
+type CacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// CacheIdentifier is implemented by signers and token sources whose Authorization header
+// changes between requests, e.g. with a timestamp or a rotated token. Responses cached for
+// requests they authorize are keyed by the identity instead of the header, so it must be stable
+// across processes and differ between credentials, e.g. a hash of the access key.
+type CacheIdentifier interface {
+	CacheIdentity() string
+}
+
+// CacheConfig configuration of the response cache of an HTTPClient.
+type CacheConfig struct {
+	// Store required, e.g. NewLRUCacheStore or NewDiskCacheStore.
+	Store CacheStore
+	// MaxEntrySize larger responses are streamed to the caller and not cached, default 8MB.
+	MaxEntrySize int64
+	// KeyHeaders request headers which are part of the cache key, so that responses are not
+	// shared between credentials or representations. Defaults to DefaultCacheKeyHeaders.
+	KeyHeaders []string
+}
+
+// DefaultCacheKeyHeaders credential and content negotiation headers which responses are
+// cached by.
+var DefaultCacheKeyHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-Api-Key",
+	"Accept",
+	"Accept-Language",
+}
+
+// SetCache 为GET请求启用响应缓存, 遵循Cache-Control/Expires, 过期后用ETag/Last-Modified重新验证,
+// 并合并并发的相同请求. 不同凭证(CacheConfig.KeyHeaders)的响应分开缓存
+func (c *HTTPClient) SetCache(cfg CacheConfig) *HTTPClient {
+	if cfg.MaxEntrySize <= 0 {
+		cfg.MaxEntrySize = 8 << 20
+	}
+	if cfg.KeyHeaders == nil {
+		cfg.KeyHeaders = DefaultCacheKeyHeaders
+	}
+	c.cache = &responseCache{cfg: cfg, flights: make(map[string]*cacheFlight)}
+	return c
+}
+
+type responseCache struct {
+	cfg CacheConfig
+
+	mu      sync.Mutex
+	flights map[string]*cacheFlight
+}
+
+// cacheFlight a request in flight which identical concurrent requests wait for.
+type cacheFlight struct {
+	done  chan struct{}
+	entry *cacheEntry
+	err   error
+}
+
+// cacheEntry a stored response.
+type cacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// StoredAt when the response was received or last revalidated.
+	StoredAt time.Time
+	// Vary request header values selected by the Vary response header.
+	Vary map[string]string
+}
+
+// cacheIdentityKey context key of the cache identity of the credentials of a request.
+type cacheIdentityKey struct{}
+
+// withCacheIdentity marks requests whose signers and token source all implement
+// CacheIdentifier, so that the response cache keys them by their identities instead of the
+// Authorization header they set.
+func (c *HTTPClient) withCacheIdentity(ctx context.Context, args *RequestArgs) context.Context {
+	if c.cache == nil {
+		return ctx
+	}
+	var ids []string
+	if c.tokens != nil {
+		id := c.tokens.CacheIdentity()
+		if id == "" {
+			return ctx
+		}
+		ids = append(ids, "token-source "+id)
+	}
+	signers := append([]Signer{args.Signer}, c.signers...)
+	for _, s := range signers {
+		if s == nil {
+			continue
+		}
+		id, ok := s.(CacheIdentifier)
+		if !ok {
+			return ctx
+		}
+		ids = append(ids, id.CacheIdentity())
+	}
+	if len(ids) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, cacheIdentityKey{}, strings.Join(ids, "\n"))
+}
+
+// cacheKey responses are not shared between hosts and credentials, the values of the key headers
+// are hashed so that secrets are not stored in the key. Requests marked by withCacheIdentity are
+// keyed by the identity of their credentials instead of the Authorization header, so that cached
+// responses survive signatures and token rotation. Other Authorization values are part of the
+// key, a rotated static token starts a new cache.
+func (rc *responseCache) cacheKey(req *http.Request) string {
+	identity, _ := req.Context().Value(cacheIdentityKey{}).(string)
+	h := sha256.New()
+	n := 0
+	for _, name := range rc.cfg.KeyHeaders {
+		name = http.CanonicalHeaderKey(name)
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		n++
+		if name == "Authorization" && identity != "" {
+			values = []string{identity}
+		}
+		h.Write([]byte(name + ":" + strings.Join(values, ",") + "\n"))
+	}
+	// virtual hosts behind the same url serve different responses
+	base := req.URL.String()
+	if req.Host != "" && !strings.EqualFold(req.Host, req.URL.Host) {
+		base += " host=" + strings.ToLower(req.Host)
+	}
+	if n == 0 {
+		return base
+	}
+	return base + " " + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (rc *responseCache) wrap(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+		if req.Method != http.MethodGet || req.Header.Get("Range") != "" || reqCC.has("no-store") {
+			return next.RoundTrip(req)
+		}
+		key := rc.cacheKey(req)
+
+		cached := rc.load(key, req)
+		if cached != nil && !reqCC.has("no-cache") && cached.fresh(time.Now()) {
+			return cached.response(req, CacheHit), nil
+		}
+
+		// 合并并发的相同请求
+		rc.mu.Lock()
+		if f, ok := rc.flights[key]; ok {
+			rc.mu.Unlock()
+			select {
+			case <-f.done:
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			if f.entry != nil && f.entry.matches(req) {
+				return f.entry.response(req, CacheHit), nil
+			}
+			// the response of the leader varies by headers this request differs in
+			if f.entry != nil {
+				return next.RoundTrip(req)
+			}
+			// the leader was canceled or its response was not shareable
+			if f.err == nil || errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded) {
+				return next.RoundTrip(req)
+			}
+			return nil, f.err
+		}
+		f := &cacheFlight{done: make(chan struct{})}
+		rc.flights[key] = f
+		rc.mu.Unlock()
+
+		rsp, entry, err := rc.fetch(next, req, key, cached)
+		f.entry, f.err = entry, err
+		rc.mu.Lock()
+		delete(rc.flights, key)
+		rc.mu.Unlock()
+		close(f.done)
+		return rsp, err
+	})
+}
+
+// fetch sends the request, conditionally if a stale entry has validators, and stores the response.
+func (rc *responseCache) fetch(next http.RoundTripper, req *http.Request, key string, cached *cacheEntry) (*http.Response, *cacheEntry, error) {
+	out := req
+	if cached != nil {
+		etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			out = req.Clone(req.Context())
+			if etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				out.Header.Set("If-Modified-Since", lastModified)
+			}
+		}
+	}
+	rsp, err := next.RoundTrip(out)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rsp.StatusCode == http.StatusNotModified && cached != nil && out != req {
+		discardBody(rsp)
+		// 304 refreshes the freshness headers of the stored response
+		for _, h := range []string{"Cache-Control", "Date", "Expires", "ETag", "Last-Modified"} {
+			if v := rsp.Header.Get(h); v != "" {
+				cached.Header.Set(h, v)
+			}
+		}
+		cached.Header.Del("Age")
+		cached.StoredAt = time.Now()
+		rc.store(key, cached)
+		return cached.response(req, CacheRevalidated), cached, nil
+	}
+
+	if !cacheable(rsp) {
+		rsp.Header.Set(CacheStatusHeader, CacheMiss)
+		return rsp, nil, nil
+	}
+	if rsp.ContentLength > rc.cfg.MaxEntrySize {
+		rsp.Header.Set(CacheStatusHeader, CacheMiss)
+		return rsp, nil, nil
+	}
+	buf := bytes.NewBuffer(nil)
+	n, err := buf.ReadFrom(io.LimitReader(rsp.Body, rc.cfg.MaxEntrySize+1))
+	if err != nil {
+		rsp.Body.Close()
+		return nil, nil, err
+	}
+	if n > rc.cfg.MaxEntrySize {
+		// too large to cache, hand the rest of the body through
+		rsp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(buf, rsp.Body), rsp.Body}
+		rsp.Header.Set(CacheStatusHeader, CacheMiss)
+		return rsp, nil, nil
+	}
+	rsp.Body.Close()
+
+	entry := &cacheEntry{
+		StatusCode: rsp.StatusCode,
+		Header:     rsp.Header.Clone(),
+		Body:       buf.Bytes(),
+		StoredAt:   time.Now(),
+		Vary:       varyValues(req, rsp.Header),
+	}
+	rc.store(key, entry)
+	return entry.response(req, CacheMiss), entry, nil
+}
+
+func (rc *responseCache) load(key string, req *http.Request) *cacheEntry {
+	data, ok := rc.cfg.Store.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(data, entry); err != nil {
+		rc.cfg.Store.Delete(key)
+		return nil
+	}
+	if !entry.matches(req) {
+		return nil
+	}
+	return entry
+}
+
+func (rc *responseCache) store(key string, entry *cacheEntry) {
+	if data, err := json.Marshal(entry); err == nil {
+		rc.cfg.Store.Set(key, data)
+	}
+}
+
+// matches reports whether req has the header values the entry varies by.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for h, v := range e.Vary {
+		if req.Header.Get(h) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// response builds a response of the entry for req.
+func (e *cacheEntry) response(req *http.Request, status string) *http.Response {
+	header := e.Header.Clone()
+	header.Set(CacheStatusHeader, status)
+	return &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// fresh reports whether the entry may be served without revalidation.
+func (e *cacheEntry) fresh(now time.Time) bool {
+	cc := parseCacheControl(e.Header.Get("Cache-Control"))
+	if cc.has("no-cache") {
+		return false
+	}
+	var lifetime time.Duration
+	if maxAge, ok := cc.seconds("max-age"); ok {
+		lifetime = maxAge
+	} else if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return false
+		}
+		date := e.StoredAt
+		if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+			date = d
+		}
+		lifetime = t.Sub(date)
+	}
+	age := now.Sub(e.StoredAt)
+	if initial, err := strconv.Atoi(e.Header.Get("Age")); err == nil && initial > 0 {
+		age += time.Duration(initial) * time.Second
+	}
+	return age < lifetime
+}
+
+// cacheable reports whether a response may be stored, responses without freshness or
+// validators are useless to store.
+func cacheable(rsp *http.Response) bool {
+	if rsp.StatusCode != http.StatusOK {
+		return false
+	}
+	cc := parseCacheControl(rsp.Header.Get("Cache-Control"))
+	if cc.has("no-store") {
+		return false
+	}
+	// Vary: * never matches another request
+	for _, v := range rsp.Header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if strings.TrimSpace(h) == "*" {
+				return false
+			}
+		}
+	}
+	if _, ok := cc.seconds("max-age"); ok {
+		return true
+	}
+	return rsp.Header.Get("Expires") != "" || rsp.Header.Get("ETag") != "" || rsp.Header.Get("Last-Modified") != ""
+}
+
+func varyValues(req *http.Request, header http.Header) map[string]string {
+	var vary map[string]string
+	for _, v := range header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if h = http.CanonicalHeaderKey(strings.TrimSpace(h)); h != "" && h != "*" {
+				if vary == nil {
+					vary = make(map[string]string)
+				}
+				vary[h] = req.Header.Get(h)
+			}
+		}
+	}
+	return vary
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(v string) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		cc[strings.ToLower(name)] = value
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
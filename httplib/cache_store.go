@@ -0,0 +1,140 @@
+package httplib
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LRUCacheStore in-memory CacheStore evicting the least recently used entries.
+type LRUCacheStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key   string
+	value []byte
+}
+
+// NewLRUCacheStore creates a store holding up to maxBytes of responses, default 64MB.
+func NewLRUCacheStore(maxBytes int64) *LRUCacheStore {
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	return &LRUCacheStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get ...
+func (s *LRUCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*lruItem).value, true
+}
+
+// Set ...
+func (s *LRUCacheStore) Set(key string, value []byte) {
+	if int64(len(value)) > s.maxBytes {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.removeLocked(el)
+	}
+	s.items[key] = s.order.PushFront(&lruItem{key: key, value: value})
+	s.size += int64(len(value))
+	for s.size > s.maxBytes {
+		s.removeLocked(s.order.Back())
+	}
+}
+
+// Delete ...
+func (s *LRUCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.removeLocked(el)
+	}
+}
+
+// Len returns the number of entries.
+func (s *LRUCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *LRUCacheStore) removeLocked(el *list.Element) {
+	item := s.order.Remove(el).(*lruItem)
+	delete(s.items, item.key)
+	s.size -= int64(len(item.value))
+}
+
+// DiskCacheStore CacheStore keeping one file per entry in a directory, so that the cache
+// survives restarts. It does not evict entries.
+type DiskCacheStore struct {
+	dir string
+}
+
+// NewDiskCacheStore creates a store in dir, creating it if necessary.
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCacheStore{dir: dir}, nil
+}
+
+func (s *DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get ...
+func (s *DiskCacheStore) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set writes the entry to a temporary file and renames it, readers never see a partial entry.
+func (s *DiskCacheStore) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// Delete ...
+func (s *DiskCacheStore) Delete(key string) {
+	os.Remove(s.path(key))
+}
@@ -0,0 +1,225 @@
+package httplib_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newCacheServer(hits *int32) *httptest.Server {
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/modified":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("Last-Modified", lastModified)
+			if r.Header.Get("If-Modified-Since") == lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/slow":
+			w.Header().Set("Cache-Control", "max-age=60")
+			time.Sleep(50 * time.Millisecond)
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/host":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("body of " + r.Host))
+			return
+		case "/key":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("body of " + r.Header.Get("X-Api-Key")))
+			return
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "X-Chain")
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("body of " + r.Header.Get("X-Chain")))
+			return
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	}))
+}
+
+func cachedGet(t *testing.T, client *httplib.HTTPClient, url string) (string, string) {
+	headers := map[string][]string{}
+	body := bytes.NewBuffer(nil)
+	require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: url, BytesResult: body, ResponseHeaders: headers}))
+	return body.String(), http.Header(headers).Get(httplib.CacheStatusHeader)
+}
+
+func TestHttpCache(t *testing.T) {
+	var hits int32
+	srv := newCacheServer(&hits)
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: httplib.NewLRUCacheStore(0)})
+
+	for _, tc := range []struct {
+		path   string
+		second string
+		hits   int32
+	}{
+		{"/fresh", httplib.CacheHit, 1},
+		{"/etag", httplib.CacheRevalidated, 2},
+		{"/modified", httplib.CacheRevalidated, 2},
+		{"/nostore", httplib.CacheMiss, 2},
+	} {
+		atomic.StoreInt32(&hits, 0)
+		body, status := cachedGet(t, client, srv.URL+tc.path)
+		require.Equal(t, "body of "+tc.path, body)
+		require.Equal(t, httplib.CacheMiss, status)
+		body, status = cachedGet(t, client, srv.URL+tc.path)
+		require.Equal(t, "body of "+tc.path, body, tc.path)
+		require.Equal(t, tc.second, status, tc.path)
+		require.Equal(t, tc.hits, atomic.LoadInt32(&hits), tc.path)
+	}
+
+	// the request may bypass the cache
+	atomic.StoreInt32(&hits, 0)
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/fresh", Headers: map[string]string{"Cache-Control": "no-store"}})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, hits)
+}
+
+func TestHttpCache_CollapseConcurrent(t *testing.T) {
+	var hits int32
+	srv := newCacheServer(&hits)
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: httplib.NewLRUCacheStore(0)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := bytes.NewBuffer(nil)
+			require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/slow", BytesResult: body}))
+			require.Equal(t, "body of /slow", body.String())
+		}()
+	}
+	wg.Wait()
+	require.EqualValues(t, 1, hits)
+}
+
+func TestHttpCache_Key(t *testing.T) {
+	var hits int32
+	srv := newCacheServer(&hits)
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: httplib.NewLRUCacheStore(0)})
+	get := func(headers map[string]string) string {
+		body := bytes.NewBuffer(nil)
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/key", Headers: headers, BytesResult: body}))
+		return body.String()
+	}
+
+	// responses are not shared between api keys
+	require.Equal(t, "body of a", get(map[string]string{"X-Api-Key": "a"}))
+	require.Equal(t, "body of b", get(map[string]string{"X-Api-Key": "b"}))
+	require.Equal(t, "body of a", get(map[string]string{"X-Api-Key": "a"}))
+	require.Equal(t, "body of ", get(nil))
+	require.EqualValues(t, 3, hits)
+}
+
+func TestHttpCache_Host(t *testing.T) {
+	var hits int32
+	srv := newCacheServer(&hits)
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: httplib.NewLRUCacheStore(0)})
+	get := func(host string) string {
+		body := bytes.NewBuffer(nil)
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/host", Host: host, BytesResult: body}))
+		return body.String()
+	}
+
+	// virtual hosts behind the same url are cached apart
+	require.Equal(t, "body of a.example", get("a.example"))
+	require.Equal(t, "body of b.example", get("b.example"))
+	require.Equal(t, "body of a.example", get("a.example"))
+	require.EqualValues(t, 2, hits)
+}
+
+func TestHttpCache_CollapseVary(t *testing.T) {
+	var hits int32
+	srv := newCacheServer(&hits)
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: httplib.NewLRUCacheStore(0)})
+
+	// concurrent requests differing in a header the response varies by get their own response
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		chain := []string{"eth", "bsc"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := bytes.NewBuffer(nil)
+			err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/vary", Headers: map[string]string{"X-Chain": chain}, BytesResult: body})
+			if err == nil && body.String() != "body of "+chain {
+				err = errors.New(chain + " got " + body.String())
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestHttpCache_DiskStore(t *testing.T) {
+	var hits int32
+	srv := newCacheServer(&hits)
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "httplib-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 2; i++ {
+		store, err := httplib.NewDiskCacheStore(dir)
+		require.NoError(t, err)
+		client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: store})
+		body, _ := cachedGet(t, client, srv.URL+"/fresh")
+		require.Equal(t, "body of /fresh", body)
+	}
+	require.EqualValues(t, 1, hits)
+}
+
+func TestLRUCacheStore(t *testing.T) {
+	store := httplib.NewLRUCacheStore(10)
+	store.Set("a", []byte("aaaa"))
+	store.Set("b", []byte("bbbb"))
+	_, ok := store.Get("a")
+	require.True(t, ok)
+	store.Set("c", []byte("cccc"))
+	_, ok = store.Get("b")
+	require.False(t, ok)
+	_, ok = store.Get("a")
+	require.True(t, ok)
+	store.Set("big", make([]byte, 11))
+	require.Equal(t, 2, store.Len())
+	store.Delete("a")
+	require.Equal(t, 1, store.Len())
+}
@@ -0,0 +1,374 @@
+package httplib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BalanceStrategy how an EndpointPool picks the endpoint of an attempt.
+type BalanceStrategy int
+
+const (
+	// RoundRobin cycles through the healthy endpoints.
+	RoundRobin BalanceStrategy = iota
+	// Weighted smooth weighted round robin by Endpoint.Weight.
+	Weighted
+	// LeastLatency picks the healthy endpoint with the lowest average latency. Averages decay
+	// with the age of the last measurement, so that endpoints which were slow are tried again.
+	LeastLatency
+)
+
+// Endpoint a base url of a pool, e.g. "https://eth-mainnet.provider.io/v2".
+type Endpoint struct {
+	URL string
+	// Weight share of the traffic for the Weighted strategy, default 1.
+	Weight int
+}
+
+// HealthCheck actively probes every endpoint with a GET request through the transport of the
+// client the pool is set on, so that probes use its TLS and proxy configuration.
+type HealthCheck struct {
+	// Path probed relative to the endpoint, a 2xx response marks the endpoint healthy.
+	Path string
+	// Interval between probes, default 10s.
+	Interval time.Duration
+	// Timeout of a probe, default 2s.
+	Timeout time.Duration
+}
+
+// EndpointPoolConfig configuration of an EndpointPool.
+type EndpointPoolConfig struct {
+	Endpoints []Endpoint
+	Strategy  BalanceStrategy
+	// MaxFailures consecutive failed attempts which eject an endpoint, default 3.
+	MaxFailures int
+	// EjectDuration how long an ejected endpoint is skipped, default 30s.
+	EjectDuration time.Duration
+	// IsFailure reports whether an attempt failed, defaults to errors and 5xx responses.
+	IsFailure func(rsp *http.Response, err error) bool
+	// HealthCheck enables active health checks, optional. Without it endpoints are only
+	// ejected passively by the outcome of requests.
+	HealthCheck *HealthCheck
+	// LatencyHalfLife how fast the latency of an endpoint which is not measured decays for
+	// LeastLatency, default 30s.
+	LatencyHalfLife time.Duration
+}
+
+func (cfg EndpointPoolConfig) withDefaults() EndpointPoolConfig {
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = 3
+	}
+	if cfg.EjectDuration <= 0 {
+		cfg.EjectDuration = 30 * time.Second
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = defaultIsFailure
+	}
+	if cfg.LatencyHalfLife <= 0 {
+		cfg.LatencyHalfLife = 30 * time.Second
+	}
+	if hc := cfg.HealthCheck; hc != nil {
+		c := *hc
+		if c.Interval <= 0 {
+			c.Interval = 10 * time.Second
+		}
+		if c.Timeout <= 0 {
+			c.Timeout = 2 * time.Second
+		}
+		cfg.HealthCheck = &c
+	}
+	return cfg
+}
+
+// EndpointStats snapshot of an endpoint of a pool.
+type EndpointStats struct {
+	URL     string
+	Healthy bool
+	// ConsecutiveFailures failed attempts since the last success.
+	ConsecutiveFailures int
+	// Latency moving average of the attempt latency.
+	Latency  time.Duration
+	Requests uint64
+	Failures uint64
+}
+
+type endpoint struct {
+	url    string
+	weight int
+
+	// guarded by EndpointPool.mu
+	current      int
+	failures     int
+	ejectedUntil time.Time
+	latency      time.Duration
+	measured     time.Time
+	requests     uint64
+	failed       uint64
+}
+
+// EndpointPool balances requests of an HTTPClient over redundant endpoints serving the
+// same api, ejecting failing endpoints and failing over to the next one.
+//
+// Every attempt of a request goes to an endpoint it has not tried yet while one is left, so the
+// retry policy of the client bounds the failover. Without a retry policy idempotent requests
+// failing with a retryable error are sent to each endpoint once, without backoff.
+type EndpointPool struct {
+	cfg       EndpointPoolConfig
+	endpoints []*endpoint
+
+	mu   sync.Mutex
+	next int
+
+	stop    chan struct{}
+	started bool
+	wg      sync.WaitGroup
+}
+
+// NewEndpointPool creates a pool, active health checks start once it is set on a client.
+func NewEndpointPool(cfg EndpointPoolConfig) (*EndpointPool, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("httplib: endpoint pool without endpoints")
+	}
+	cfg = cfg.withDefaults()
+	p := &EndpointPool{cfg: cfg, stop: make(chan struct{})}
+	for _, e := range cfg.Endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("httplib: invalid endpoint url " + e.URL)
+		}
+		weight := e.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		p.endpoints = append(p.endpoints, &endpoint{url: strings.TrimRight(e.URL, "/"), weight: weight})
+	}
+	return p, nil
+}
+
+// SetEndpointPool 设置多个后端地址做负载均衡和故障转移, 设置后RequestArgs.URL为相对路径
+// 主动健康检查使用该client的transport, 在第一次设置时开始
+func (c *HTTPClient) SetEndpointPool(p *EndpointPool) *HTTPClient {
+	c.endpoints = p
+	if p != nil {
+		// the transport may be replaced later by SetTransportConfig
+		p.startHealthCheck(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if c.transport == nil {
+				return http.DefaultTransport.RoundTrip(req)
+			}
+			return c.transport.RoundTrip(req)
+		}))
+	}
+	return c
+}
+
+// startHealthCheck starts the active health checks once, probing through transport.
+func (p *EndpointPool) startHealthCheck(transport http.RoundTripper) {
+	if p.cfg.HealthCheck == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+	if p.started {
+		return
+	}
+	p.started = true
+	p.wg.Add(1)
+	go p.healthCheck(&http.Client{Transport: transport, Timeout: p.cfg.HealthCheck.Timeout})
+}
+
+// Close stops the active health checks.
+func (p *EndpointPool) Close() {
+	p.mu.Lock()
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Stats returns a snapshot of every endpoint.
+func (p *EndpointPool) Stats() []EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	stats := make([]EndpointStats, len(p.endpoints))
+	for i, e := range p.endpoints {
+		stats[i] = EndpointStats{
+			URL:                 e.url,
+			Healthy:             e.healthy(now),
+			ConsecutiveFailures: e.failures,
+			Latency:             e.latency,
+			Requests:            e.requests,
+			Failures:            e.failed,
+		}
+	}
+	return stats
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return !now.Before(e.ejectedUntil)
+}
+
+// resolve joins the endpoint with a path relative to the pool, absolute urls are kept.
+func (e *endpoint) resolve(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	if path == "" || strings.HasPrefix(path, "?") {
+		return e.url + path
+	}
+	return e.url + "/" + strings.TrimLeft(path, "/")
+}
+
+// pick chooses the endpoint of an attempt, preferring healthy endpoints which the request
+// has not tried yet. When every endpoint is ejected the pool fails open.
+func (p *EndpointPool) pick(tried map[*endpoint]bool) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var candidates []*endpoint
+	for _, filter := range []func(e *endpoint) bool{
+		func(e *endpoint) bool { return e.healthy(now) && !tried[e] },
+		func(e *endpoint) bool { return !tried[e] },
+		func(e *endpoint) bool { return e.healthy(now) },
+		func(e *endpoint) bool { return true },
+	} {
+		for _, e := range p.endpoints {
+			if filter(e) {
+				candidates = append(candidates, e)
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+
+	switch p.cfg.Strategy {
+	case Weighted:
+		var best *endpoint
+		total := 0
+		for _, e := range candidates {
+			e.current += e.weight
+			total += e.weight
+			if best == nil || e.current > best.current {
+				best = e
+			}
+		}
+		best.current -= total
+		return best
+	case LeastLatency:
+		// endpoints without measurements are tried first
+		best, bestLatency := candidates[0], candidates[0].decayedLatency(now, p.cfg.LatencyHalfLife)
+		for _, e := range candidates[1:] {
+			if latency := e.decayedLatency(now, p.cfg.LatencyHalfLife); latency < bestLatency {
+				best, bestLatency = e, latency
+			}
+		}
+		return best
+	default:
+		e := candidates[p.next%len(candidates)]
+		p.next++
+		return e
+	}
+}
+
+// decayedLatency halves the latency of e for every halfLife since it was measured.
+func (e *endpoint) decayedLatency(now time.Time, halfLife time.Duration) time.Duration {
+	halvings := now.Sub(e.measured) / halfLife
+	if halvings >= 63 {
+		return 0
+	}
+	return e.latency >> uint(halvings)
+}
+
+// observe adds a latency measurement to the moving average of e.
+func (e *endpoint) observe(latency time.Duration, now time.Time) {
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = (e.latency*7 + latency*3) / 10
+	}
+	e.measured = now
+}
+
+// untried reports whether the request may still fail over to another endpoint.
+func (p *EndpointPool) untried(tried map[*endpoint]bool) bool {
+	return len(tried) < len(p.endpoints)
+}
+
+// record updates the passive health and latency of e with the outcome of an attempt.
+func (p *EndpointPool) record(e *endpoint, rsp *http.Response, err error, latency time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failure := p.cfg.IsFailure(rsp, err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.requests++
+	e.observe(latency, time.Now())
+	if !failure {
+		e.failures = 0
+		return
+	}
+	e.failed++
+	if e.failures++; e.failures >= p.cfg.MaxFailures {
+		e.ejectedUntil = time.Now().Add(p.cfg.EjectDuration)
+	}
+}
+
+// failover reports whether a failed attempt is sent to the next endpoint when the client
+// has no retry policy, only idempotent requests fail over.
+func (p *EndpointPool) failover(tried map[*endpoint]bool, method string, args *RequestArgs, rsp *http.Response, err error) bool {
+	if !p.untried(tried) || (!isIdempotent(method) && !args.Idempotent) {
+		return false
+	}
+	return DefaultRetryOn(rsp, err)
+}
+
+func (p *EndpointPool) healthCheck(client *http.Client) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		for _, e := range p.endpoints {
+			p.probe(client, e)
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *EndpointPool) probe(client *http.Client, e *endpoint) {
+	ok := false
+	start := time.Now()
+	if rsp, err := client.Get(e.resolve(p.cfg.HealthCheck.Path)); err == nil {
+		discardBody(rsp)
+		ok = rsp.StatusCode >= 200 && rsp.StatusCode < 300
+	}
+	latency := time.Since(start)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		// probes keep the latency of endpoints which get no traffic up to date
+		e.observe(latency, time.Now())
+		e.failures = 0
+		e.ejectedUntil = time.Time{}
+	} else {
+		e.ejectedUntil = time.Now().Add(p.cfg.EjectDuration)
+	}
+}
@@ -0,0 +1,217 @@
+package httplib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newCountingServer(status *int32, hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+		w.Write([]byte(r.URL.Path))
+	}))
+}
+
+func TestEndpointPool_RoundRobin(t *testing.T) {
+	var statusA, statusB int32 = http.StatusOK, http.StatusOK
+	var hitsA, hitsB int32
+	a, b := newCountingServer(&statusA, &hitsA), newCountingServer(&statusB, &hitsB)
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{
+		Endpoints: []httplib.Endpoint{{URL: a.URL + "/v1/"}, {URL: b.URL + "/v1"}},
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	client := httplib.NewHTTPClient().SetEndpointPool(pool)
+
+	for i := 0; i < 4; i++ {
+		result := bytes.NewBuffer(nil)
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/blocks", BytesResult: result}))
+		require.Equal(t, "/v1/blocks", result.String())
+	}
+	require.EqualValues(t, 2, hitsA)
+	require.EqualValues(t, 2, hitsB)
+}
+
+func TestEndpointPool_Failover(t *testing.T) {
+	var statusA, statusB int32 = http.StatusBadGateway, http.StatusOK
+	var hitsA, hitsB int32
+	a, b := newCountingServer(&statusA, &hitsA), newCountingServer(&statusB, &hitsB)
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{
+		Endpoints:   []httplib.Endpoint{{URL: a.URL}, {URL: b.URL}},
+		MaxFailures: 2,
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	client := httplib.NewHTTPClient().SetEndpointPool(pool)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	}
+	// a is ejected after two failures, every request succeeds on b
+	require.EqualValues(t, 2, hitsA)
+	require.EqualValues(t, 4, hitsB)
+	stats := pool.Stats()
+	require.False(t, stats[0].Healthy)
+	require.True(t, stats[1].Healthy)
+	require.EqualValues(t, 2, stats[0].Failures)
+
+	// POST is not failed over
+	atomic.StoreInt32(&statusB, http.StatusBadGateway)
+	err = client.Post(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/", Body: []byte("{}")})
+	require.Error(t, err)
+	require.EqualValues(t, 5, hitsB)
+}
+
+func TestEndpointPool_BreakerSkip(t *testing.T) {
+	statuses := []int32{http.StatusInternalServerError, http.StatusOK, http.StatusOK}
+	hits := make([]int32, len(statuses))
+	var endpoints []httplib.Endpoint
+	for i := range statuses {
+		srv := newCountingServer(&statuses[i], &hits[i])
+		defer srv.Close()
+		endpoints = append(endpoints, httplib.Endpoint{URL: srv.URL})
+	}
+	pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{Endpoints: endpoints})
+	require.NoError(t, err)
+	client := httplib.NewHTTPClient().SetEndpointPool(pool).SetBreaker(httplib.BreakerConfig{WindowSize: 1, MinRequests: 1})
+
+	// the breaker of the first endpoint opens
+	require.Error(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	}
+
+	// skipping the open breaker does not use up the retry of the second endpoint's failure
+	atomic.StoreInt32(&statuses[1], http.StatusServiceUnavailable)
+	policy := httplib.DefaultRetryPolicy()
+	policy.MaxAttempts = 2
+	policy.InitialBackoff = time.Millisecond
+	client.SetRetryPolicy(policy)
+	require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	require.Equal(t, []int32{1, 2, 2}, hits)
+}
+
+func TestEndpointPool_WeightedAndHealthCheck(t *testing.T) {
+	var statusA, statusB int32 = http.StatusOK, http.StatusServiceUnavailable
+	var hitsA, hitsB int32
+	a, b := newCountingServer(&statusA, &hitsA), newCountingServer(&statusB, &hitsB)
+	defer a.Close()
+	defer b.Close()
+
+	pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{
+		Endpoints:   []httplib.Endpoint{{URL: a.URL, Weight: 3}, {URL: b.URL, Weight: 1}},
+		Strategy:    httplib.Weighted,
+		HealthCheck: &httplib.HealthCheck{Path: "/health", Interval: 20 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	// probes start once the pool is set on a client
+	httplib.NewHTTPClient().SetEndpointPool(pool)
+
+	require.Eventually(t, func() bool {
+		stats := pool.Stats()
+		return stats[0].Healthy && !stats[1].Healthy
+	}, time.Second, 10*time.Millisecond)
+
+	atomic.StoreInt32(&statusB, http.StatusOK)
+	require.Eventually(t, func() bool { return pool.Stats()[1].Healthy }, time.Second, 10*time.Millisecond)
+
+	pool.Close()
+	atomic.StoreInt32(&hitsA, 0)
+	atomic.StoreInt32(&hitsB, 0)
+	client := httplib.NewHTTPClient().SetEndpointPool(pool)
+	for i := 0; i < 8; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	}
+	require.EqualValues(t, 6, hitsA)
+	require.EqualValues(t, 2, hitsB)
+}
+
+func TestEndpointPool_HealthCheckTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// the probe trusts the certificate of the server only through the tls config of the client
+	newPool := func() *httplib.EndpointPool {
+		pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{
+			Endpoints:   []httplib.Endpoint{{URL: srv.URL}},
+			HealthCheck: &httplib.HealthCheck{Path: "/health", Interval: 20 * time.Millisecond},
+		})
+		require.NoError(t, err)
+		return pool
+	}
+	pool := newPool()
+	defer pool.Close()
+	httplib.NewHTTPClient().SetEndpointPool(pool)
+	require.Eventually(t, func() bool { return !pool.Stats()[0].Healthy }, time.Second, 10*time.Millisecond)
+
+	pool = newPool()
+	defer pool.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	httplib.NewHTTPSClient(&tls.Config{RootCAs: roots}).SetEndpointPool(pool)
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, pool.Stats()[0].Healthy)
+	require.NotZero(t, pool.Stats()[0].Latency)
+}
+
+func TestEndpointPool_LeastLatency(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer slow.Close()
+	var status int32 = http.StatusOK
+	var hits int32
+	fast := newCountingServer(&status, &hits)
+	defer fast.Close()
+
+	pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{
+		Endpoints: []httplib.Endpoint{{URL: slow.URL}, {URL: fast.URL}},
+		Strategy:  httplib.LeastLatency,
+	})
+	require.NoError(t, err)
+	client := httplib.NewHTTPClient().SetEndpointPool(pool)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	}
+	require.EqualValues(t, 4, hits)
+
+	// the latency of the slow endpoint decays until it is measured again
+	pool, err = httplib.NewEndpointPool(httplib.EndpointPoolConfig{
+		Endpoints:       []httplib.Endpoint{{URL: slow.URL}, {URL: fast.URL}},
+		Strategy:        httplib.LeastLatency,
+		LatencyHalfLife: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	client = httplib.NewHTTPClient().SetEndpointPool(pool)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	}
+	slowRequests := pool.Stats()[0].Requests
+	require.EqualValues(t, 1, slowRequests)
+	require.Eventually(t, func() bool {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+		return pool.Stats()[0].Requests > slowRequests
+	}, 2*time.Second, 5*time.Millisecond)
+
+	_, err = httplib.NewEndpointPool(httplib.EndpointPoolConfig{Endpoints: []httplib.Endpoint{{URL: "/relative"}}})
+	require.Error(t, err)
+}
@@ -0,0 +1,111 @@
+package httplib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrIDStatusCodeNotOK the status code is not in RequestArgs.ExpectedStatusCode, the error
+// wraps an *HTTPError.
+const ErrIDStatusCodeNotOK = "HTTP_STATUS_CODE_NOT_OK"
+
+// HTTPError a response with an unexpected status code, get it with AsHTTPError or errors.As.
+type HTTPError struct {
+	StatusCode int
+	Header     http.Header
+	// Body the raw body, truncated to 64KB.
+	Body []byte
+	// ErrorResult RequestArgs.ErrorResult with the body decoded into it, nil if it was not
+	// set or the body could not be decoded.
+	ErrorResult interface{}
+	// Code the grpc code of the status code, see SetStatusCodes.
+	Code codes.Code
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected http status %d", e.StatusCode)
+}
+
+// Temporary reports whether the request may succeed later.
+func (e *HTTPError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// AsHTTPError returns the HTTPError of a request failed by an unexpected status code.
+func AsHTTPError(err error) (*HTTPError, bool) {
+	var e *HTTPError
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// DefaultStatusCodes maps http status codes to grpc codes, unlisted 4xx codes map to
+// FailedPrecondition and unlisted 5xx codes to Internal.
+var DefaultStatusCodes = map[int]codes.Code{
+	http.StatusBadRequest:                   codes.InvalidArgument,
+	http.StatusUnauthorized:                 codes.Unauthenticated,
+	http.StatusForbidden:                    codes.PermissionDenied,
+	http.StatusNotFound:                     codes.NotFound,
+	http.StatusMethodNotAllowed:             codes.Unimplemented,
+	http.StatusRequestTimeout:               codes.DeadlineExceeded,
+	http.StatusConflict:                     codes.Aborted,
+	http.StatusPreconditionFailed:           codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge:        codes.InvalidArgument,
+	http.StatusRequestedRangeNotSatisfiable: codes.OutOfRange,
+	http.StatusTooManyRequests:              codes.ResourceExhausted,
+	499:                                     codes.Canceled,
+	http.StatusInternalServerError:          codes.Internal,
+	http.StatusNotImplemented:               codes.Unimplemented,
+	http.StatusBadGateway:                   codes.Unavailable,
+	http.StatusServiceUnavailable:           codes.Unavailable,
+	http.StatusGatewayTimeout:               codes.DeadlineExceeded,
+}
+
+// SetStatusCodes 设置状态码到grpc code的映射, 覆盖 DefaultStatusCodes 中的对应项
+func (c *HTTPClient) SetStatusCodes(table map[int]codes.Code) *HTTPClient {
+	c.statusCodes = table
+	return c
+}
+
+// grpcCode maps an unexpected status code to a grpc code.
+func (c *HTTPClient) grpcCode(status int) codes.Code {
+	if code, ok := c.statusCodes[status]; ok {
+		return code
+	}
+	if code, ok := DefaultStatusCodes[status]; ok {
+		return code
+	}
+	switch {
+	case status >= 500:
+		return codes.Internal
+	case status >= 400:
+		return codes.FailedPrecondition
+	}
+	return codes.Unknown
+}
+
+// decodeErrorResult decodes an error body into RequestArgs.ErrorResult.
+func (c *HTTPClient) decodeErrorResult(args *RequestArgs, body []byte) interface{} {
+	if args.ErrorResult == nil || len(body) == 0 {
+		return nil
+	}
+	if msg, ok := args.ErrorResult.(proto.Message); ok && args.ProtobufType {
+		if err := jsonpb.UnmarshalString(string(body), msg); err != nil {
+			return nil
+		}
+		return msg
+	}
+	if err := json.Unmarshal(body, args.ErrorResult); err != nil {
+		return nil
+	}
+	return args.ErrorResult
+}
@@ -0,0 +1,77 @@
+package httplib_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func TestHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.Header().Set("X-Request-Id", "req-1")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"code":"token_not_found","message":"unknown token"}`))
+	}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient()
+
+	var payload apiError
+	err := client.Get(context.Background(), &httplib.RequestArgs{
+		TraceID:     "fakeID",
+		URL:         srv.URL,
+		Params:      map[string]string{"status": "404"},
+		ErrorResult: &payload,
+	})
+	require.Error(t, err)
+	sysErr := err.(*syserror.SysError)
+	require.Equal(t, httplib.ErrIDStatusCodeNotOK, sysErr.ID)
+	require.Equal(t, codes.NotFound, sysErr.Code)
+	require.NotEmpty(t, sysErr.MemoryValues["RequestArgs"])
+
+	httpErr, ok := httplib.AsHTTPError(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, httpErr.StatusCode)
+	require.Equal(t, "req-1", httpErr.Header.Get("X-Request-Id"))
+	require.Contains(t, string(httpErr.Body), "token_not_found")
+	require.Equal(t, &apiError{Code: "token_not_found", Message: "unknown token"}, httpErr.ErrorResult)
+	require.Equal(t, "unknown token", payload.Message)
+	require.False(t, httpErr.Temporary())
+
+	var target *httplib.HTTPError
+	require.True(t, errors.As(err, &target))
+
+	for status, code := range map[string]codes.Code{
+		"429": codes.ResourceExhausted,
+		"503": codes.Unavailable,
+		"507": codes.Internal,
+		"418": codes.FailedPrecondition,
+	} {
+		err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, Params: map[string]string{"status": status}})
+		httpErr, ok = httplib.AsHTTPError(err)
+		require.True(t, ok)
+		require.Equal(t, code, httpErr.Code, status)
+		require.Nil(t, httpErr.ErrorResult)
+	}
+
+	client.SetStatusCodes(map[int]codes.Code{http.StatusTeapot: codes.Unavailable})
+	err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, Params: map[string]string{"status": "418"}})
+	require.Equal(t, codes.Unavailable, err.(*syserror.SysError).Code)
+
+	_, ok = httplib.AsHTTPError(client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "http://127.0.0.1:1"}))
+	require.False(t, ok)
+}
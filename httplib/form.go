@@ -0,0 +1,173 @@
+package httplib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/DeBankDeFi/golib/syserror"
+)
+
+// Content types set automatically for form bodies.
+const (
+	ContentTypeForm      = "application/x-www-form-urlencoded"
+	ContentTypeMultipart = "multipart/form-data"
+)
+
+// Multipart a multipart/form-data request body, pass it as RequestArgs.Body.
+//
+// Files are streamed from their readers while the request is sent, so a request is only
+// retried if every reader implements io.Seeker and can be rewound.
+type Multipart struct {
+	// Fields plain form fields, sent before the files.
+	Fields url.Values
+	Files  []FormFile
+}
+
+// FormFile a file part of a multipart body.
+type FormFile struct {
+	// Field form field name, required.
+	Field string
+	// Name file name reported to the server.
+	Name string
+	// ContentType of the file, defaults to application/octet-stream.
+	ContentType string
+	// Reader content of the file, required.
+	Reader io.Reader
+}
+
+// requestBody the body of a request, generated once and sent with every attempt.
+type requestBody struct {
+	data        []byte
+	contentType string
+
+	multipart *Multipart
+	boundary  string
+	// offsets start offsets of seekable file readers, nil if a reader is not seekable
+	offsets []int64
+	// pipe and written of the previous attempt, the writer must be done before rewinding
+	pipe    *io.PipeReader
+	written chan struct{}
+}
+
+// newMultipartBody validates m and records where its readers start.
+func newMultipartBody(args *RequestArgs, m *Multipart) (*requestBody, error) {
+	b := &requestBody{
+		multipart: m,
+		boundary:  multipart.NewWriter(nil).Boundary(),
+		offsets:   make([]int64, len(m.Files)),
+	}
+	b.contentType = ContentTypeMultipart + "; boundary=" + b.boundary
+	for i, f := range m.Files {
+		if f.Field == "" || f.Reader == nil {
+			return nil, syserror.New(args.TraceID, "MULTIPART_BODY", "form file requires a field and a reader", map[string]interface{}{
+				"Field": f.Field,
+				"Name":  f.Name,
+			})
+		}
+		seeker, ok := f.Reader.(io.Seeker)
+		if !ok {
+			b.offsets = nil
+			continue
+		}
+		if b.offsets != nil {
+			offset, err := seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				b.offsets = nil
+				continue
+			}
+			b.offsets[i] = offset
+		}
+	}
+	return b, nil
+}
+
+// replayable reports whether the body can be sent again by a retry.
+func (b *requestBody) replayable() bool {
+	return b == nil || b.multipart == nil || b.offsets != nil
+}
+
+// reader returns the body of an attempt, streamed bodies are rewound for retries.
+func (b *requestBody) reader(attempt int) (io.Reader, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if b.multipart == nil {
+		if len(b.data) == 0 {
+			return nil, nil
+		}
+		return bytes.NewReader(b.data), nil
+	}
+	if attempt > 1 {
+		if b.offsets == nil {
+			return nil, errors.New("multipart body cannot be rewound")
+		}
+		// the transport may still be reading the previous attempt, e.g. when the server
+		// answered before reading the body, stop its writer before seeking the readers
+		if b.pipe != nil {
+			b.pipe.CloseWithError(errors.New("multipart body rewound"))
+			<-b.written
+		}
+		for i, f := range b.multipart.Files {
+			if _, err := f.Reader.(io.Seeker).Seek(b.offsets[i], io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+	}
+	pr, pw := io.Pipe()
+	written := make(chan struct{})
+	b.pipe, b.written = pr, written
+	go func() {
+		defer close(written)
+		pw.CloseWithError(b.writeMultipart(pw))
+	}()
+	return pr, nil
+}
+
+// writeMultipart writes the parts, it stops when the transport closes the reading side.
+func (b *requestBody) writeMultipart(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(b.boundary); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(b.multipart.Fields))
+	for k := range b.multipart.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range b.multipart.Fields[k] {
+			if err := mw.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range b.multipart.Files {
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="`+escapeQuotes(f.Field)+`"; filename="`+escapeQuotes(f.Name)+`"`)
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(part, f.Reader); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
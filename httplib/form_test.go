@@ -0,0 +1,175 @@
+package httplib_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpFormBody(t *testing.T) {
+	var contentType string
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+	}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient()
+
+	body := url.Values{"name": {"debank"}, "tags": {"a", "b"}}
+	require.NoError(t, client.Post(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, Body: body}))
+	require.Equal(t, httplib.ContentTypeForm, contentType)
+	require.Equal(t, body, form)
+
+	// an explicit header wins
+	err := client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Body:    body,
+		Headers: map[string]string{"Content-Type": httplib.ContentTypeForm + "; charset=utf-8"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, httplib.ContentTypeForm+"; charset=utf-8", contentType)
+}
+
+type multipartUpload struct {
+	fields url.Values
+	files  map[string]string
+	names  map[string]string
+}
+
+func TestHttpMultipartBody(t *testing.T) {
+	var attempts int32
+	var upload multipartUpload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		upload = multipartUpload{fields: url.Values(r.MultipartForm.Value), files: map[string]string{}, names: map[string]string{}}
+		for field, headers := range r.MultipartForm.File {
+			f, err := headers[0].Open()
+			require.NoError(t, err)
+			data, _ := ioutil.ReadAll(f)
+			upload.files[field] = string(data)
+			upload.names[field] = headers[0].Filename
+		}
+	}))
+	defer srv.Close()
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.RetryNonIdempotent = true
+	client := httplib.NewHTTPClient().SetRetryPolicy(policy)
+
+	// seekable readers are rewound for the retry
+	err := client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Body: &httplib.Multipart{
+			Fields: url.Values{"chain": {"eth"}},
+			Files: []httplib.FormFile{
+				{Field: "abi", Name: "erc20.json", ContentType: "application/json", Reader: strings.NewReader(`[{"type":"function"}]`)},
+				{Field: "logo", Name: "logo.png", Reader: strings.NewReader("png")},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, attempts)
+	require.Equal(t, url.Values{"chain": {"eth"}}, upload.fields)
+	require.Equal(t, map[string]string{"abi": `[{"type":"function"}]`, "logo": "png"}, upload.files)
+	require.Equal(t, "erc20.json", upload.names["abi"])
+
+	// other readers are sent once
+	atomic.StoreInt32(&attempts, 0)
+	err = client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Body: httplib.Multipart{Files: []httplib.FormFile{
+			{Field: "data", Name: "data.bin", Reader: io.MultiReader(strings.NewReader("data"))},
+		}},
+	})
+	require.Error(t, err)
+	require.EqualValues(t, 1, attempts)
+
+	err = client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Body:    &httplib.Multipart{Files: []httplib.FormFile{{Field: "data"}}},
+	})
+	require.Error(t, err)
+	require.Equal(t, "MULTIPART_BODY", err.(*syserror.SysError).ID)
+}
+
+// guardedReader fails reads which overlap a seek, i.e. a rewind racing the previous attempt.
+type guardedReader struct {
+	r       io.ReadSeeker
+	reading int32
+	raced   int32
+}
+
+func (g *guardedReader) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&g.reading, 1)
+	defer atomic.StoreInt32(&g.reading, 0)
+	// slow reads widen the window of the race
+	time.Sleep(time.Millisecond)
+	return g.r.Read(p)
+}
+
+func (g *guardedReader) Seek(offset int64, whence int) (int64, error) {
+	if atomic.LoadInt32(&g.reading) == 1 {
+		atomic.StoreInt32(&g.raced, 1)
+	}
+	return g.r.Seek(offset, whence)
+}
+
+func TestHttpMultipartBody_EarlyResponse(t *testing.T) {
+	data := strings.Repeat("0123456789abcdef", 64<<10)
+	var attempts int32
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails before the body is read
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		f, _, err := r.FormFile("data")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(f)
+		received <- string(body)
+	}))
+	defer srv.Close()
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := httplib.NewHTTPClient().SetRetryPolicy(policy)
+
+	reader := &guardedReader{r: strings.NewReader(data)}
+	err := client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID:    "fakeID",
+		URL:        srv.URL,
+		Idempotent: true,
+		Body:       &httplib.Multipart{Files: []httplib.FormFile{{Field: "data", Name: "data.bin", Reader: reader}}},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, attempts)
+	require.Equal(t, data, <-received)
+	require.Zero(t, atomic.LoadInt32(&reader.raced))
+}
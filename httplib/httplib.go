@@ -8,14 +8,26 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"reflect"
 	"time"
 
 	"github.com/DeBankDeFi/golib/syserror"
+	"github.com/DeBankDeFi/golib/util"
 
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+)
+
+// syserror IDs which callers may branch on.
+const (
+	// ErrIDRequestCanceled the request context was canceled.
+	ErrIDRequestCanceled = "HTTP_REQUEST_CANCELED"
+	// ErrIDRequestTimeout the request context deadline or the client timeout was exceeded.
+	ErrIDRequestTimeout = "HTTP_REQUEST_TIMEOUT"
 )
 
 type BasicAuth struct {
@@ -39,7 +51,8 @@ type RequestArgs struct {
 	// Request params, optional.
 	Params map[string]string
 
-	// Request Body, optional.
+	// Request Body, optional. []byte is sent as is, url.Values as a url-encoded form and
+	// *Multipart as multipart/form-data, other values are encoded as json or protobuf json.
 	Body interface{}
 
 	ExpectedStatusCode []int
@@ -48,16 +61,33 @@ type RequestArgs struct {
 
 	BytesResult *bytes.Buffer `json:"-"`
 
+	// BodyWriter receives the response body as it is read instead of buffering it, optional.
+	// It is only written after the status code was validated.
+	BodyWriter io.Writer `json:"-"`
+
 	ResponseHeaders map[string][]string
 
 	BasicAuth    *BasicAuth
 	ProtobufType bool
 
-	ReqHandle func(req *http.Request)
+	// Idempotent allows retrying a non-idempotent method such as POST, optional.
+	Idempotent bool
+
+	// RateLimitKey takes tokens of the named limit of RateLimitConfig.Keys instead of the limit
+	// of the host, optional.
+	RateLimitKey string
+
+	// ErrorResult pointer the body of a response with an unexpected status code is decoded into,
+	// optional. See HTTPError.
+	ErrorResult interface{} `json:"-"`
+
+	ReqHandle func(req *http.Request) `json:"-"`
+
+	// Signer signs the request after the signers of the client, optional.
+	Signer Signer `json:"-"`
 }
 
 // HTTPClient 对http client的抽象
-// TODO: https support
 type HTTPClient struct {
 	// http请求超时时间(默认30s)
 	timeout time.Duration
@@ -65,6 +95,42 @@ type HTTPClient struct {
 	// TLS的配置, 如果该项非空, 则使用HTTPS进行请求(可选)
 	tlsConfig *tls.Config
 
+	// 长连接复用的transport, 所有请求共享
+	transport *http.Transport
+
+	// 重试策略, 默认不重试(可选)
+	retryPolicy *RetryPolicy
+
+	// 按host或key限流, 默认不限流(可选)
+	limiter *rateLimiter
+
+	// 按host熔断, 默认不熔断(可选)
+	breakers *breakerGroup
+
+	// 多后端负载均衡和故障转移(可选)
+	endpoints *EndpointPool
+
+	// 状态码到grpc code的映射(可选)
+	statusCodes map[int]codes.Code
+
+	// 缓存读取的响应Body上限, 0表示不限制(可选)
+	maxBodySize int64
+
+	// GET请求的响应缓存(可选)
+	cache *responseCache
+
+	// OAuth2 token来源(可选)
+	tokens *CachedTokenSource
+
+	// 请求签名, 每次尝试发送前执行(可选)
+	signers []Signer
+
+	// 中间件, 作用于每次请求(可选)
+	middlewares []Middleware
+
+	// 链路追踪请求头
+	tracePropagation TracePropagation
+
 	protoMarshaler jsonpb.Marshaler
 }
 
@@ -73,17 +139,18 @@ func NewHTTPClient() *HTTPClient {
 	return &HTTPClient{
 		timeout:        30 * time.Second,
 		tlsConfig:      nil,
+		transport:      newTransport(DefaultTransportConfig(), nil),
 		protoMarshaler: jsonpb.Marshaler{OrigName: true},
 	}
 }
 
-// NewHTTPSClient 新建一个默认配置的https client
-// 如果tlsConfig为nil, 将退化成http请求
+// NewHTTPSClient 新建一个默认配置的https client, 除TLS配置外与NewHTTPClient一致
+// 如果tlsConfig为nil, 将退化成http请求; 证书热加载使用TLSReloader.TLSConfig()
 func NewHTTPSClient(tlsConfig *tls.Config) *HTTPClient {
-	return &HTTPClient{
-		timeout:   30 * time.Second,
-		tlsConfig: tlsConfig,
-	}
+	c := NewHTTPClient()
+	c.tlsConfig = tlsConfig
+	c.transport = newTransport(DefaultTransportConfig(), tlsConfig)
+	return c
 }
 
 // SetTimeout 设置HTTP请求超时时间，默认30s
@@ -145,13 +212,19 @@ func (c *HTTPClient) setParams(req *http.Request, args *RequestArgs) {
 	req.URL.RawQuery = query.Encode()
 }
 
-// genBody 生成请求Body
-func (c *HTTPClient) genBody(args *RequestArgs) (io.Reader, error) {
+// genBody 生成请求Body, 只生成一次, 每次重试复用
+func (c *HTTPClient) genBody(args *RequestArgs) (*requestBody, error) {
 	var err error
-	var body *bytes.Buffer
 
-	if args.Body == nil {
+	switch body := args.Body.(type) {
+	case nil:
 		return nil, nil
+	case url.Values:
+		return &requestBody{data: []byte(body.Encode()), contentType: ContentTypeForm}, nil
+	case *Multipart:
+		return newMultipartBody(args, body)
+	case Multipart:
+		return newMultipartBody(args, &body)
 	}
 	b, ok := args.Body.([]byte)
 	if !ok {
@@ -167,119 +240,277 @@ func (c *HTTPClient) genBody(args *RequestArgs) (io.Reader, error) {
 			}
 		}
 	}
-	if len(b) > 0 {
-		body = bytes.NewBuffer(b)
-	}
-	return body, nil
+	return &requestBody{data: b}, nil
 }
 
 // Get 发送HTTP Get请求, 但在发送请求之前，需要对 request 做处理，此处理函数逻辑是调用者定义的
 func (c *HTTPClient) Get(ctx context.Context, args *RequestArgs) error {
-	req, err := http.NewRequest("GET", args.URL, nil)
-	if err != nil {
-		return syserror.New(args.TraceID, "NEW_HTTP_REQUEST", err.Error(), map[string]interface{}{
-			"URL": args.URL,
-		})
-	}
-	return c.doRequest(ctx, req, args)
+	return c.do(ctx, http.MethodGet, args)
 }
 
 // Delete send a delete http request.
 func (c *HTTPClient) Delete(ctx context.Context, args *RequestArgs) error {
-	req, err := http.NewRequest(http.MethodDelete, args.URL, nil)
-	if err != nil {
-		return syserror.New(args.TraceID, "NEW_HTTP_REQUEST", err.Error(), map[string]interface{}{
-			"URL": args.URL,
-		})
-	}
-	return c.doRequest(ctx, req, args)
+	return c.do(ctx, http.MethodDelete, args)
 }
 
 // Post 发送HTTP Post请求
 func (c *HTTPClient) Post(ctx context.Context, args *RequestArgs) error {
-	body, err := c.genBody(args)
-	if err != nil {
-		return syserror.Wrap(err, "generate http post body failed")
-	}
-	req, err := http.NewRequest("POST", args.URL, body)
-	if err != nil {
-		return syserror.New(args.TraceID, "NEW_HTTP_REQUEST", err.Error(), map[string]interface{}{
-			"URL": args.URL,
-		})
-	}
-	return c.doRequest(ctx, req, args)
+	return c.do(ctx, http.MethodPost, args)
 }
 
 // Put 发送 HTTP Put 请求
 func (c *HTTPClient) Put(ctx context.Context, args *RequestArgs) error {
-	body, err := c.genBody(args)
-	if err != nil {
-		return syserror.Wrap(err, "generate http post body failed")
-	}
-	req, err := http.NewRequest("PUT", args.URL, body)
-	if err != nil {
-		return syserror.New(args.TraceID, "NEW_HTTP_REQUEST", err.Error(), map[string]interface{}{
-			"URL": args.URL,
-		})
+	return c.do(ctx, http.MethodPut, args)
+}
+
+// Patch 发送 HTTP Patch 请求
+func (c *HTTPClient) Patch(ctx context.Context, args *RequestArgs) error {
+	return c.do(ctx, http.MethodPatch, args)
+}
+
+// Head 发送 HTTP Head 请求, 只填充响应头, 不解析Body
+func (c *HTTPClient) Head(ctx context.Context, args *RequestArgs) error {
+	return c.do(ctx, http.MethodHead, args)
+}
+
+// Options 发送 HTTP Options 请求
+func (c *HTTPClient) Options(ctx context.Context, args *RequestArgs) error {
+	return c.do(ctx, http.MethodOptions, args)
+}
+
+// Do 发送任意方法的HTTP请求, 包括自定义方法
+func (c *HTTPClient) Do(ctx context.Context, method string, args *RequestArgs) error {
+	return c.do(ctx, method, args)
+}
+
+// allowsBody reports whether a request of method may carry a body.
+func allowsBody(method string) bool {
+	switch method {
+	case http.MethodHead, http.MethodTrace, http.MethodConnect:
+		return false
 	}
-	return c.doRequest(ctx, req, args)
+	return true
 }
 
-func (c *HTTPClient) doRequest(ctx context.Context, req *http.Request, args *RequestArgs) error {
-	c.setHeaders(req, args)
-	c.setParams(req, args)
-	c.setBasicAuth(req, args)
-	if ctx != nil {
-		req.WithContext(ctx)
+// newRequest creates a request bound to ctx, so that cancellation and deadline of ctx abort it.
+func newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	if ctx == nil {
+		ctx = context.Background()
 	}
-	c.handleRequest(req, args)
+	return http.NewRequestWithContext(ctx, method, url, body)
+}
 
-	cli := http.Client{
-		Timeout: c.timeout,
+// requestError distinguishes canceled and timed out requests from other failures.
+func requestError(req *http.Request, args *RequestArgs, id string, err error, fields map[string]interface{}) error {
+	code := codes.Unknown
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(req.Context().Err(), context.Canceled):
+		id, code = ErrIDRequestCanceled, codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		id, code = ErrIDRequestTimeout, codes.DeadlineExceeded
+	}
+	return syserror.NewV2(args.TraceID, id, err.Error(), syserror.WithCode(code), syserror.WithFields(fields))
+}
+
+// do 发送请求并处理响应
+func (c *HTTPClient) do(ctx context.Context, method string, args *RequestArgs) error {
+	req, rsp, attempts, err := c.send(ctx, method, args, false)
+	if err != nil {
+		return err
 	}
+	return withAttempts(c.handleResponse(req, rsp, args), attempts)
+}
 
-	// 如果TLSClientConfig非空, 将启用https进行请求
-	if c.tlsConfig != nil {
-		cli.Transport = &http.Transport{
-			TLSClientConfig: c.tlsConfig,
+// send 发送请求, 按重试策略重试, 每次重试都重新构建请求, 返回最后一次尝试的请求和响应
+// stream为true时响应Body由调用方读取, 超时只限制等待响应头和每次读取, 不限制整个请求
+func (c *HTTPClient) send(ctx context.Context, method string, args *RequestArgs, stream bool) (*http.Request, *http.Response, int, error) {
+	var body *requestBody
+	if allowsBody(method) {
+		var err error
+		if body, err = c.genBody(args); err != nil {
+			return nil, nil, 0, syserror.Wrap(err, "generate http body failed")
 		}
 	}
 
-	// 发送请求
-	rsp, err := cli.Do(req)
-	if err != nil {
-		return syserror.New(args.TraceID, "HTTP_DO_REQUEST", err.Error(), map[string]interface{}{
-			"Method":      req.Method,
-			"RequestArgs": UnsafeJsonMarshal(args),
-		})
+	// trace id 优先取参数, 其次取ctx, 并写回ctx供中间件使用
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	traceID := args.TraceID
+	if traceID == "" {
+		traceID = util.GetTraceIDFromContext(ctx)
+	} else if util.GetTraceIDFromContext(ctx) == "" {
+		ctx = util.SetTraceIDToContext(ctx, traceID)
+	}
+
+	maxAttempts := c.retryPolicy.attempts(method, args)
+	if !body.replayable() {
+		maxAttempts = 1
+	}
+	ctx = c.withCacheIdentity(ctx, args)
+	tried := make(map[*endpoint]bool)
+	reauthorized := false
+	// 换新token后重试同一个后端
+	var reauthEp *endpoint
+	for attempt := 1; ; attempt++ {
+		// 多后端时每次尝试选择一个未尝试过的后端
+		url := args.URL
+		var ep *endpoint
+		if reauthEp != nil {
+			ep, reauthEp = reauthEp, nil
+			url = ep.resolve(args.URL)
+		} else if c.endpoints != nil {
+			ep = c.endpoints.pick(tried)
+			tried[ep] = true
+			url = ep.resolve(args.URL)
+		}
+		r, err := body.reader(attempt)
+		if err != nil {
+			return nil, nil, attempt, syserror.New(args.TraceID, "REWIND_HTTP_BODY", err.Error(), map[string]interface{}{
+				"URL": url,
+			})
+		}
+		req, err := newRequest(ctx, method, url, r)
+		if err != nil {
+			return nil, nil, attempt, syserror.New(args.TraceID, "NEW_HTTP_REQUEST", err.Error(), map[string]interface{}{
+				"URL": url,
+			})
+		}
+		c.setTraceHeaders(req, traceID)
+		if body != nil && body.contentType != "" {
+			req.Header.Set("Content-Type", body.contentType)
+		}
+		c.setHeaders(req, args)
+		c.setParams(req, args)
+		c.setBasicAuth(req, args)
+		c.handleRequest(req, args)
+
+		// 限流, 等待令牌
+		if c.limiter != nil {
+			if err = c.limiter.wait(req.Context(), args, req.URL.Host); err != nil {
+				if req.Body != nil {
+					req.Body.Close()
+				}
+				if _, ok := err.(*syserror.SysError); !ok {
+					err = requestError(req, args, "HTTP_RATE_LIMIT_WAIT", err, nil)
+				}
+				return nil, nil, attempt, withAttempts(err, attempt)
+			}
+		}
+
+		// OAuth2 token和签名, 在限流等待之后, 保证时间戳有效
+		token, err := c.authorize(req, args)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, nil, attempt, withAttempts(err, attempt)
+		}
+		if err = c.sign(req, body, args); err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, nil, attempt, withAttempts(syserror.NewV2(args.TraceID, ErrIDSignRequest, err.Error(), syserror.WithCode(codes.Unauthenticated), syserror.WithFields(map[string]interface{}{
+				"Method": req.Method,
+				"URL":    url,
+			})), attempt)
+		}
+
+		// 熔断检查, 多后端时转移到下一个后端
+		var breaker *hostBreaker
+		if c.breakers != nil {
+			breaker = c.breakers.get(req.URL.Host)
+			if !breaker.allow() {
+				// 未发送的流式Body需要关闭, 否则写入的goroutine不会退出
+				if req.Body != nil {
+					req.Body.Close()
+				}
+				// 跳过的后端未发送请求, 不计入重试次数
+				if ep != nil && c.endpoints.untried(tried) && body.replayable() {
+					maxAttempts++
+					continue
+				}
+				return nil, nil, attempt, withAttempts(syserror.NewV2(args.TraceID, ErrIDCircuitOpen, "circuit breaker is open", syserror.WithCode(codes.Unavailable), syserror.WithFields(map[string]interface{}{
+					"Host":   req.URL.Host,
+					"Method": req.Method,
+					"URL":    url,
+				})), attempt)
+			}
+		}
+
+		// 发送请求
+		start := time.Now()
+		client := c.httpClient()
+		if stream {
+			client = c.streamClient()
+		}
+		rsp, err := client.Do(req)
+		latency := time.Since(start)
+		if breaker != nil {
+			breaker.record(rsp, err, latency)
+		}
+		if ep != nil {
+			c.endpoints.record(ep, rsp, err, latency)
+		}
+		// token被拒绝时换新token重试一次, 不计入重试次数
+		if !reauthorized && body.replayable() && c.reauthorize(token, rsp) {
+			reauthorized = true
+			maxAttempts++
+			reauthEp = ep
+			discardBody(rsp)
+			continue
+		}
+		if attempt < maxAttempts {
+			if wait, ok := c.retryPolicy.retry(attempt, rsp, err); ok {
+				discardBody(rsp)
+				if err = sleepContext(req.Context(), wait); err == nil {
+					continue
+				}
+			}
+		} else if ep != nil && c.retryPolicy == nil && body.replayable() && c.endpoints.failover(tried, method, args, rsp, err) {
+			discardBody(rsp)
+			continue
+		}
+		if err != nil {
+			return nil, nil, attempt, withAttempts(requestError(req, args, "HTTP_DO_REQUEST", err, map[string]interface{}{
+				"Method":      req.Method,
+				"URL":         url,
+				"RequestArgs": UnsafeJsonMarshal(args),
+			}), attempt)
+		}
+		return req, rsp, attempt, nil
 	}
+}
+
+// handleResponse 校验状态码并解析响应
+func (c *HTTPClient) handleResponse(req *http.Request, rsp *http.Response, args *RequestArgs) (err error) {
 	defer rsp.Body.Close()
 
-	// 读取响应Body
-	body := bytes.NewBuffer(nil)
-	if _, err = body.ReadFrom(rsp.Body); err != nil {
-		return syserror.New(args.TraceID, "HTTP_READ_RSP_BODY", err.Error(), nil)
+	// 状态码校验
+	if err = c.checkStatus(req, rsp, args); err != nil {
+		return err
 	}
 
-	// 状态码校验
-	var requestOK bool
-	var expectedStatusCode = args.ExpectedStatusCode
-	if len(expectedStatusCode) == 0 {
-		expectedStatusCode = []int{http.StatusOK}
+	// HEAD 请求没有Body
+	if req.Method == http.MethodHead {
+		c.copyHeaders(rsp, args)
+		return nil
 	}
-	for _, code := range expectedStatusCode {
-		if code == rsp.StatusCode {
-			requestOK = true
-			break
+
+	// 流式写入调用者的Writer, 不缓存响应Body
+	if args.BodyWriter != nil {
+		c.copyHeaders(rsp, args)
+		if _, err = io.Copy(args.BodyWriter, rsp.Body); err != nil {
+			return requestError(req, args, "HTTP_READ_RSP_BODY", err, nil)
 		}
+		return nil
 	}
-	if requestOK == false {
-		return syserror.New(args.TraceID, "HTTP_STATUS_CODE_NOT_OK", "StatusCode not in expected list", map[string]interface{}{
-			"StatusCode":         rsp.StatusCode,
-			"ExpectedStatusCode": expectedStatusCode,
-			"ResponseBody":       body.String(),
-			"RequestArgs":        UnsafeJsonMarshal(args),
-		})
+
+	// 读取响应Body
+	body, err := c.readBody(req, rsp, args)
+	if err != nil {
+		return err
 	}
 
 	// 结果解析
@@ -309,12 +540,66 @@ func (c *HTTPClient) doRequest(ctx context.Context, req *http.Request, args *Req
 			})
 		}
 	}
+	c.copyHeaders(rsp, args)
+	return nil
+}
+
+// checkStatus 校验状态码, 失败时读取部分响应Body用于排查
+func (c *HTTPClient) checkStatus(req *http.Request, rsp *http.Response, args *RequestArgs) error {
+	var expectedStatusCode = args.ExpectedStatusCode
+	if len(expectedStatusCode) == 0 {
+		expectedStatusCode = []int{http.StatusOK}
+	}
+	for _, code := range expectedStatusCode {
+		if code == rsp.StatusCode {
+			return nil
+		}
+	}
+	body := bytes.NewBuffer(nil)
+	if req.Method != http.MethodHead {
+		body.ReadFrom(io.LimitReader(rsp.Body, maxErrorBodySize))
+	}
+	httpErr := &HTTPError{
+		StatusCode:  rsp.StatusCode,
+		Header:      rsp.Header,
+		Body:        body.Bytes(),
+		ErrorResult: c.decodeErrorResult(args, body.Bytes()),
+		Code:        c.grpcCode(rsp.StatusCode),
+	}
+	return syserror.NewV2(args.TraceID, ErrIDStatusCodeNotOK, "StatusCode not in expected list", syserror.WithCode(httpErr.Code), syserror.WithCause(httpErr), syserror.WithFields(map[string]interface{}{
+		"StatusCode":         rsp.StatusCode,
+		"ExpectedStatusCode": expectedStatusCode,
+		"ResponseBody":       body.String(),
+		"RequestArgs":        UnsafeJsonMarshal(args),
+	}))
+}
+
+// readBody 读取响应Body, 超过maxBodySize时报错
+func (c *HTTPClient) readBody(req *http.Request, rsp *http.Response, args *RequestArgs) (*bytes.Buffer, error) {
+	var r io.Reader = rsp.Body
+	if c.maxBodySize > 0 {
+		if rsp.ContentLength > c.maxBodySize {
+			return nil, bodyTooLarge(args, rsp.ContentLength, c.maxBodySize)
+		}
+		r = io.LimitReader(rsp.Body, c.maxBodySize+1)
+	}
+	body := bytes.NewBuffer(nil)
+	n, err := body.ReadFrom(r)
+	if err != nil {
+		return nil, requestError(req, args, "HTTP_READ_RSP_BODY", err, nil)
+	}
+	if c.maxBodySize > 0 && n > c.maxBodySize {
+		return nil, bodyTooLarge(args, n, c.maxBodySize)
+	}
+	return body, nil
+}
+
+func (c *HTTPClient) copyHeaders(rsp *http.Response, args *RequestArgs) {
 	if args.ResponseHeaders != nil {
 		for k, v := range rsp.Header {
 			args.ResponseHeaders[k] = v
 		}
 	}
-	return nil
 }
 
 func UnsafeJsonMarshal(v interface{}) string {
@@ -4,14 +4,42 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
 )
 
+var updateFixtures = flag.Bool("update", false, "record the http fixtures in testdata from the network")
+
+// withFixtures replays the requests of client from testdata/<test name>.json, or records
+// them with -update. The checked in fixtures are synthetic, see testdata/README.md.
+func withFixtures(t *testing.T, client *httplib.HTTPClient) *httplib.HTTPClient {
+	mode := httplib.ModeReplay
+	if *updateFixtures {
+		mode = httplib.ModeRecord
+	}
+	rec, err := httplib.NewRecorder(httplib.RecorderConfig{
+		Path:   "testdata/" + t.Name() + ".json",
+		Mode:   mode,
+		Strict: true,
+	})
+	require.NoError(t, err)
+	return client.Use(rec.Middleware())
+}
+
 func TestHttpGet(t *testing.T) {
 	result := bytes.NewBuffer(nil)
-	err := httplib.NewHTTPClient().Get(context.Background(), &httplib.RequestArgs{
+	err := withFixtures(t, httplib.NewHTTPClient()).Get(context.Background(), &httplib.RequestArgs{
 		TraceID: "fakeID",
 		URL:     "http://www.sina.cn",
 		Headers: map[string]string{
@@ -31,7 +59,7 @@ func TestHttpGet(t *testing.T) {
 func TestHttpResponseHeader(t *testing.T) {
 	result := bytes.NewBuffer(nil)
 	respHeaders := make(map[string][]string, 0)
-	err := httplib.NewHTTPClient().Get(context.Background(), &httplib.RequestArgs{
+	err := withFixtures(t, httplib.NewHTTPClient()).Get(context.Background(), &httplib.RequestArgs{
 		TraceID: "fakeID",
 		URL:     "http://www.sina.cn",
 		Headers: map[string]string{
@@ -55,7 +83,7 @@ func TestHttpResponseHeader(t *testing.T) {
 
 func TestHttpPost(t *testing.T) {
 	result := bytes.NewBuffer(nil)
-	err := httplib.NewHTTPClient().Post(context.Background(), &httplib.RequestArgs{
+	err := withFixtures(t, httplib.NewHTTPClient()).Post(context.Background(), &httplib.RequestArgs{
 		TraceID: "fakeID",
 		URL:     "http://www.sina.cn",
 		Headers: map[string]string{
@@ -76,9 +104,9 @@ func TestHttpPost(t *testing.T) {
 
 func TestHttpsGet(t *testing.T) {
 	result := bytes.NewBuffer(nil)
-	err := httplib.NewHTTPSClient(
+	err := withFixtures(t, httplib.NewHTTPSClient(
 		&tls.Config{InsecureSkipVerify: true},
-	).Get(context.Background(), &httplib.RequestArgs{
+	)).Get(context.Background(), &httplib.RequestArgs{
 		TraceID: "fakeID",
 		URL:     "https://www.sina.cn",
 		Headers: map[string]string{
@@ -97,9 +125,9 @@ func TestHttpsGet(t *testing.T) {
 
 func TestHttpsPost(t *testing.T) {
 	result := bytes.NewBuffer(nil)
-	err := httplib.NewHTTPSClient(
+	err := withFixtures(t, httplib.NewHTTPSClient(
 		&tls.Config{InsecureSkipVerify: true},
-	).Post(context.Background(), &httplib.RequestArgs{
+	)).Post(context.Background(), &httplib.RequestArgs{
 		TraceID: "fakeID",
 		URL:     "https://www.sina.cn",
 		Headers: map[string]string{
@@ -117,3 +145,89 @@ func TestHttpsPost(t *testing.T) {
 	}
 	t.Log("OK")
 }
+
+func TestHttpContextCancel(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := httplib.NewHTTPClient().Get(ctx, &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+	})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDRequestCanceled, err.(*syserror.SysError).ID)
+	require.Equal(t, codes.Canceled, err.(*syserror.SysError).Code)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = httplib.NewHTTPClient().Post(ctx, &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Body:    []byte("body"),
+	})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDRequestTimeout, err.(*syserror.SysError).ID)
+
+	err = httplib.NewHTTPClient().SetTimeout(50*time.Millisecond).Get(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+	})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDRequestTimeout, err.(*syserror.SysError).ID)
+}
+
+func TestHttpContextValues(t *testing.T) {
+	type ctxKey struct{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+	err := httplib.NewHTTPClient().Get(ctx, &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		ReqHandle: func(req *http.Request) {
+			require.Equal(t, "value", req.Context().Value(ctxKey{}))
+		},
+	})
+	require.NoError(t, err)
+}
+
+func TestHttpConnectionReuse(t *testing.T) {
+	var newConns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := httplib.NewHTTPSClient(&tls.Config{InsecureSkipVerify: true}).SetTransportConfig(httplib.TransportConfig{
+		MaxIdleConnsPerHost: 4,
+		DisableHTTP2:        true,
+	})
+	defer client.Close()
+	for i := 0; i < 5; i++ {
+		result := bytes.NewBuffer(nil)
+		err := client.Get(context.Background(), &httplib.RequestArgs{
+			TraceID:     "fakeID",
+			URL:         srv.URL,
+			BytesResult: result,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "ok", result.String())
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&newConns))
+}
@@ -0,0 +1,423 @@
+// Package httpmock provides an httptest server with expected calls, for testing HTTP clients
+// against canned responses, delays and faults without external services.
+//
+//	srv := httpmock.NewServer(t)
+//	srv.Expect(http.MethodGet, "/v1/price").Query("token", "eth").Times(2).Reply(http.StatusServiceUnavailable)
+//	srv.Expect(http.MethodGet, "/v1/price").Query("token", "eth").ReplyJSON(http.StatusOK, price)
+//	// ... send requests to srv.URL, the expectations are verified when the test ends
+package httpmock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TestingT the subset of *testing.T the server reports to.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// Fault a failure injected instead of a response.
+type Fault int
+
+const (
+	// NoFault replies normally.
+	NoFault Fault = iota
+	// FaultConnectionClose closes the connection without a response.
+	FaultConnectionClose
+	// FaultPartialBody announces the body length and closes the connection half way.
+	FaultPartialBody
+)
+
+// Server an httptest server answering expected calls.
+type Server struct {
+	*httptest.Server
+	t TestingT
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+	unexpected   []string
+}
+
+// Call a request received by the server.
+type Call struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// NewServer starts a server, it is closed and its expectations are verified when the test ends.
+func NewServer(t TestingT) *Server {
+	s := &Server{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(func() {
+		s.Close()
+		s.Verify()
+	})
+	return s
+}
+
+// Expect registers an expected call, by default it must be made exactly once. Expectations
+// are matched in the order they were registered, an expectation whose calls are used up is
+// skipped, so that sequences of responses can be expressed.
+func (s *Server) Expect(method, path string) *Expectation {
+	e := &Expectation{
+		method:  method,
+		path:    path,
+		query:   url.Values{},
+		headers: http.Header{},
+		times:   1,
+		status:  http.StatusOK,
+		replyH:  http.Header{},
+		mu:      &s.mu,
+	}
+	s.mu.Lock()
+	s.expectations = append(s.expectations, e)
+	s.mu.Unlock()
+	return e
+}
+
+// Calls returns the requests received so far, matched or not.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Verify reports unmet expectations and unexpected calls to the test, it returns whether
+// everything went as expected. It is called at the end of the test and may be called earlier.
+func (s *Server) Verify() bool {
+	s.t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := true
+	for _, e := range s.expectations {
+		if e.times >= 0 && e.calls < e.times {
+			s.t.Errorf("httpmock: expected %d call(s) of %s, got %d", e.times, e, e.calls)
+			ok = false
+		}
+	}
+	for _, u := range s.unexpected {
+		s.t.Errorf("httpmock: unexpected call %s", u)
+		ok = false
+	}
+	// report unexpected calls once
+	s.unexpected = nil
+	return ok
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	call := Call{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+	var matched *Expectation
+	var index int
+	for _, e := range s.expectations {
+		if (e.times < 0 || e.calls < e.times) && e.matches(&call) {
+			// reply with a copy, the test may still change the expectation
+			copied := *e
+			copied.replyH = e.replyH.Clone()
+			matched = &copied
+			e.calls++
+			index = e.calls
+			break
+		}
+	}
+	if matched == nil {
+		desc := call.Method + " " + r.URL.RequestURI()
+		if len(body) > 0 {
+			desc += " " + string(body)
+		}
+		s.unexpected = append(s.unexpected, desc)
+	}
+	s.mu.Unlock()
+
+	if matched == nil {
+		http.Error(w, "httpmock: unexpected request", http.StatusNotImplemented)
+		return
+	}
+	matched.reply(w, r, &call, index)
+}
+
+// Expectation an expected call and its response. Its methods may be called while the server
+// handles requests.
+type Expectation struct {
+	// mu the lock of the server, guarding every field
+	mu *sync.Mutex
+
+	method    string
+	path      string
+	query     url.Values
+	headers   http.Header
+	body      interface{}
+	bodyMatch func(body []byte) bool
+
+	// times -1 means any number of calls
+	times int
+	calls int
+
+	status   int
+	replyH   http.Header
+	replyB   []byte
+	template *template.Template
+	delay    time.Duration
+	fault    Fault
+}
+
+func (e *Expectation) String() string {
+	s := e.method + " " + e.path
+	if len(e.query) > 0 {
+		s += "?" + e.query.Encode()
+	}
+	return s
+}
+
+// Query expects a query parameter, other parameters are ignored.
+func (e *Expectation) Query(key, value string) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.query.Add(key, value)
+	return e
+}
+
+// Header expects a request header.
+func (e *Expectation) Header(key, value string) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.headers.Add(key, value)
+	return e
+}
+
+// JSONBody expects a json body equal to v after decoding both.
+func (e *Expectation) JSONBody(v interface{}) *Expectation {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("httpmock: invalid json body: %v", err))
+	}
+	var body interface{}
+	json.Unmarshal(data, &body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.body = body
+	return e
+}
+
+// BodyMatch expects a body accepted by match.
+func (e *Expectation) BodyMatch(match func(body []byte) bool) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.bodyMatch = match
+	return e
+}
+
+// Times expects n calls.
+func (e *Expectation) Times(n int) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.times = n
+	return e
+}
+
+// AnyTimes allows any number of calls, including none.
+func (e *Expectation) AnyTimes() *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.times = -1
+	return e
+}
+
+// Reply answers with status and an empty body.
+func (e *Expectation) Reply(status int) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = status
+	return e
+}
+
+// ReplyBody answers with status and body.
+func (e *Expectation) ReplyBody(status int, body []byte) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status, e.replyB = status, body
+	return e
+}
+
+// ReplyJSON answers with status and v encoded as json.
+func (e *Expectation) ReplyJSON(status int, v interface{}) *Expectation {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("httpmock: invalid json reply: %v", err))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.replyH.Set("Content-Type", "application/json")
+	e.status, e.replyB = status, data
+	return e
+}
+
+// ReplyTemplate answers with status and a text/template rendered per call. The template sees
+// .Method, .Path, .Query, .Header, .Body (the decoded json body, or the raw body as a string)
+// and .Call, the 1-based number of the call of the expectation.
+func (e *Expectation) ReplyTemplate(status int, text string) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status = status
+	e.template = template.Must(template.New(e.String()).Parse(text))
+	return e
+}
+
+// ReplyHeader adds a response header.
+func (e *Expectation) ReplyHeader(key, value string) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.replyH.Add(key, value)
+	return e
+}
+
+// Delay waits before replying, or until the client gives up.
+func (e *Expectation) Delay(d time.Duration) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.delay = d
+	return e
+}
+
+// Fault replies with a fault instead of a response.
+func (e *Expectation) Fault(f Fault) *Expectation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fault = f
+	return e
+}
+
+func (e *Expectation) matches(c *Call) bool {
+	if e.method != c.Method || e.path != c.Path {
+		return false
+	}
+	for k, values := range e.query {
+		if !containsAll(c.Query[k], values) {
+			return false
+		}
+	}
+	for k, values := range e.headers {
+		if !containsAll(c.Header.Values(k), values) {
+			return false
+		}
+	}
+	if e.body != nil {
+		var body interface{}
+		if json.Unmarshal(c.Body, &body) != nil || !reflect.DeepEqual(e.body, body) {
+			return false
+		}
+	}
+	return e.bodyMatch == nil || e.bodyMatch(c.Body)
+}
+
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if h == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Expectation) reply(w http.ResponseWriter, r *http.Request, c *Call, index int) {
+	if e.delay > 0 {
+		timer := time.NewTimer(e.delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	body := e.replyB
+	if e.template != nil {
+		var decoded interface{} = string(c.Body)
+		var v interface{}
+		if json.Unmarshal(c.Body, &v) == nil {
+			decoded = v
+		}
+		buf := bytes.NewBuffer(nil)
+		err := e.template.Execute(buf, map[string]interface{}{
+			"Method": c.Method,
+			"Path":   c.Path,
+			"Query":  c.Query,
+			"Header": c.Header,
+			"Body":   decoded,
+			"Call":   index,
+		})
+		if err != nil {
+			http.Error(w, "httpmock: template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = buf.Bytes()
+	}
+
+	switch e.fault {
+	case FaultConnectionClose:
+		closeConnection(w)
+		return
+	case FaultPartialBody:
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)+1))
+		w.WriteHeader(e.status)
+		w.Write(body[:len(body)/2])
+		w.(http.Flusher).Flush()
+		closeConnection(w)
+		return
+	}
+
+	for k, values := range e.replyH {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	if e.template != nil && w.Header().Get("Content-Type") == "" && strings.HasPrefix(strings.TrimSpace(string(body)), "{") {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(e.status)
+	w.Write(body)
+}
+
+// closeConnection drops the connection of w.
+func closeConnection(w http.ResponseWriter) {
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
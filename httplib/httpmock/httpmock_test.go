@@ -0,0 +1,147 @@
+package httpmock_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/httplib/httpmock"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeT collects the errors of a server.
+type fakeT struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Cleanup(f func()) { t.cleanups = append(t.cleanups, f) }
+
+func (t *fakeT) finish() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func fastRetries() *httplib.HTTPClient {
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	return httplib.NewHTTPClient().SetRetryPolicy(policy)
+}
+
+func TestServer_Retries(t *testing.T) {
+	srv := httpmock.NewServer(t)
+	srv.Expect(http.MethodGet, "/v1/price").Query("token", "eth").Times(2).Reply(http.StatusServiceUnavailable)
+	srv.Expect(http.MethodGet, "/v1/price").Query("token", "eth").ReplyJSON(http.StatusOK, map[string]float64{"price": 3000})
+
+	var result map[string]float64
+	err := fastRetries().Get(context.Background(), &httplib.RequestArgs{
+		TraceID:    "fakeID",
+		URL:        srv.URL + "/v1/price",
+		Params:     map[string]string{"token": "eth"},
+		JSONResult: &result,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3000.0, result["price"])
+	require.Len(t, srv.Calls(), 3)
+}
+
+func TestServer_FaultsAndTimeouts(t *testing.T) {
+	srv := httpmock.NewServer(t)
+	srv.Expect(http.MethodGet, "/reset").Fault(httpmock.FaultConnectionClose)
+	srv.Expect(http.MethodGet, "/reset").ReplyBody(http.StatusOK, []byte("ok"))
+	srv.Expect(http.MethodGet, "/partial").ReplyBody(http.StatusOK, []byte("0123456789")).Fault(httpmock.FaultPartialBody)
+	srv.Expect(http.MethodGet, "/slow").Delay(time.Second)
+
+	result := bytes.NewBuffer(nil)
+	require.NoError(t, fastRetries().Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/reset", BytesResult: result}))
+	require.Equal(t, "ok", result.String())
+
+	err := httplib.NewHTTPClient().Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/partial", BytesResult: bytes.NewBuffer(nil)})
+	require.Error(t, err)
+
+	err = httplib.NewHTTPClient().SetTimeout(20*time.Millisecond).Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/slow"})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDRequestTimeout, err.(*syserror.SysError).ID)
+}
+
+func TestServer_Matchers(t *testing.T) {
+	srv := httpmock.NewServer(t)
+	srv.Expect(http.MethodPost, "/v1/orders").
+		Header("X-Api-Key", "key").
+		JSONBody(map[string]interface{}{"token": "eth", "amount": 2}).
+		ReplyTemplate(http.StatusCreated, `{"token":"{{.Body.token}}","call":{{.Call}}}`).
+		AnyTimes()
+
+	var result struct {
+		Token string `json:"token"`
+		Call  int    `json:"call"`
+	}
+	for i := 1; i <= 2; i++ {
+		err := httplib.NewHTTPClient().Post(context.Background(), &httplib.RequestArgs{
+			TraceID:            "fakeID",
+			URL:                srv.URL + "/v1/orders",
+			Headers:            map[string]string{"X-Api-Key": "key"},
+			Body:               map[string]interface{}{"amount": 2, "token": "eth"},
+			ExpectedStatusCode: []int{http.StatusCreated},
+			JSONResult:         &result,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "eth", result.Token)
+		require.Equal(t, i, result.Call)
+	}
+}
+
+func TestServer_Verify(t *testing.T) {
+	ft := &fakeT{}
+	srv := httpmock.NewServer(ft)
+	srv.Expect(http.MethodGet, "/called")
+	srv.Expect(http.MethodGet, "/never")
+	srv.Expect(http.MethodGet, "/optional").AnyTimes()
+
+	client := httplib.NewHTTPClient()
+	require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/called"}))
+	// the second call exceeds the expectation
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/called"})
+	httpErr, ok := httplib.AsHTTPError(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotImplemented, httpErr.StatusCode)
+
+	ft.finish()
+	require.Len(t, ft.errors, 2)
+	require.Contains(t, ft.errors[0], "GET /never")
+	require.Contains(t, ft.errors[1], "unexpected call GET /called")
+}
+
+func TestServer_ConcurrentExpectations(t *testing.T) {
+	srv := httpmock.NewServer(t)
+	e := srv.Expect(http.MethodGet, "/v1/block").AnyTimes().Reply(http.StatusOK)
+
+	// expectations may be changed and added while requests are served
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			e.ReplyHeader("X-Seq", fmt.Sprint(i)).ReplyJSON(http.StatusOK, map[string]int{"number": i})
+			srv.Expect(http.MethodGet, "/v1/tx").AnyTimes().Header("X-Chain", "eth")
+		}
+	}()
+	client := httplib.NewHTTPClient()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/v1/block"}))
+	}
+	<-done
+	require.Len(t, srv.Calls(), 50)
+}
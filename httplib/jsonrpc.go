@@ -0,0 +1,267 @@
+package httplib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/DeBankDeFi/golib/syserror"
+	"github.com/DeBankDeFi/golib/util"
+)
+
+// syserror IDs of the json-rpc client.
+const (
+	// ErrIDJSONRPC the server answered with a json-rpc error object, its code is in the
+	// JSONRPCCode field.
+	ErrIDJSONRPC = "JSONRPC_ERROR"
+	// ErrIDJSONRPCResponse the server answered with a malformed or incomplete response.
+	ErrIDJSONRPCResponse = "JSONRPC_INVALID_RESPONSE"
+)
+
+const jsonrpcVersion = "2.0"
+
+// RPCError a json-rpc 2.0 error object.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+// BatchElem one call of a batch, Result is decoded into and Error is set after the batch.
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	// Result pointer the result is decoded into, optional.
+	Result interface{}
+	// Error the json-rpc or decode error of this call.
+	Error error
+}
+
+type jsonrpcRequest struct {
+	Version string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *RPCError       `json:"error"`
+}
+
+// JSONRPCClient json-rpc 2.0 client over an HTTPClient, e.g. for ethereum node providers.
+type JSONRPCClient struct {
+	client       *HTTPClient
+	url          string
+	headers      map[string]string
+	maxBatchSize int
+	id           uint64
+}
+
+// NewJSONRPCClient creates a json-rpc client posting to url through client.
+func NewJSONRPCClient(client *HTTPClient, url string) *JSONRPCClient {
+	return &JSONRPCClient{
+		client: client,
+		url:    url,
+	}
+}
+
+// SetMaxBatchSize splits batches into requests of at most n calls, 0 means unlimited.
+// Batches rejected by the provider as too large are split further automatically.
+func (c *JSONRPCClient) SetMaxBatchSize(n int) *JSONRPCClient {
+	c.maxBatchSize = n
+	return c
+}
+
+// SetHeaders sets headers sent with every request, e.g. an api key.
+func (c *JSONRPCClient) SetHeaders(headers map[string]string) *JSONRPCClient {
+	c.headers = headers
+	return c
+}
+
+func (c *JSONRPCClient) nextID() uint64 {
+	return atomic.AddUint64(&c.id, 1)
+}
+
+// Call calls method with params and decodes the result into result, which may be nil.
+func (c *JSONRPCClient) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	elems := []BatchElem{{Method: method, Params: params, Result: result}}
+	if err := c.send(ctx, elems); err != nil {
+		return err
+	}
+	return elems[0].Error
+}
+
+// BatchCall sends all calls in as few requests as the batch size allows. The returned error
+// reports failures of the requests, errors of single calls are set in BatchElem.Error.
+func (c *JSONRPCClient) BatchCall(ctx context.Context, elems []BatchElem) error {
+	size := c.maxBatchSize
+	if size <= 0 {
+		size = len(elems)
+	}
+	for start := 0; start < len(elems); start += size {
+		end := start + size
+		if end > len(elems) {
+			end = len(elems)
+		}
+		if err := c.send(ctx, elems[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts elems as one batch, splitting it in halves if the provider rejects its size.
+func (c *JSONRPCClient) send(ctx context.Context, elems []BatchElem) error {
+	traceID := util.GetTraceIDFromContext(ctx)
+	reqs := make([]jsonrpcRequest, len(elems))
+	byID := make(map[string]int, len(elems))
+	for i, elem := range elems {
+		params := elem.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		reqs[i] = jsonrpcRequest{Version: jsonrpcVersion, ID: c.nextID(), Method: elem.Method, Params: params}
+		byID[strconv.FormatUint(reqs[i].ID, 10)] = i
+	}
+
+	var payload interface{} = reqs
+	if len(reqs) == 1 {
+		payload = reqs[0]
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return syserror.New(traceID, "JSON_MARSHAL", err.Error(), nil)
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	for k, v := range c.headers {
+		headers[k] = v
+	}
+	result := bytes.NewBuffer(nil)
+	err = c.client.Post(ctx, &RequestArgs{
+		TraceID:     traceID,
+		URL:         c.url,
+		Headers:     headers,
+		Body:        body,
+		BytesResult: result,
+	})
+	if err != nil {
+		if len(elems) > 1 && isStatusCode(err, http.StatusRequestEntityTooLarge) {
+			return c.split(ctx, elems)
+		}
+		return err
+	}
+
+	var rsps []jsonrpcResponse
+	data := bytes.TrimSpace(result.Bytes())
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &rsps)
+	} else {
+		var rsp jsonrpcResponse
+		if err = json.Unmarshal(data, &rsp); err == nil {
+			rsps = []jsonrpcResponse{rsp}
+		}
+		// a batch answered with a single error object was rejected as a whole, e.g. rate limited,
+		// the error applies to every call unless it rejects the size of the batch
+		if err == nil && len(elems) > 1 && rsp.Error != nil {
+			if isBatchSizeError(rsp.Error) {
+				return c.split(ctx, elems)
+			}
+			for i := range elems {
+				elems[i].Error = rpcError(traceID, rsp.Error, elems[i].Method)
+			}
+			return nil
+		}
+	}
+	if err != nil {
+		return syserror.New(traceID, ErrIDJSONRPCResponse, err.Error(), map[string]interface{}{
+			"ResponseBody": result.String(),
+		})
+	}
+
+	answered := make([]bool, len(elems))
+	for _, rsp := range rsps {
+		i, ok := byID[string(rsp.ID)]
+		if !ok {
+			continue
+		}
+		answered[i] = true
+		elem := &elems[i]
+		switch {
+		case rsp.Error != nil:
+			elem.Error = rpcError(traceID, rsp.Error, elem.Method)
+		case elem.Result != nil && len(rsp.Result) > 0:
+			if err := json.Unmarshal(rsp.Result, elem.Result); err != nil {
+				elem.Error = syserror.New(traceID, "JSON_UNMARSHAL", err.Error(), map[string]interface{}{
+					"Method":       elem.Method,
+					"UnmarshalRaw": string(rsp.Result),
+				})
+			}
+		}
+	}
+	for i, ok := range answered {
+		if !ok {
+			elems[i].Error = syserror.New(traceID, ErrIDJSONRPCResponse, "missing response of call", map[string]interface{}{
+				"Method": elems[i].Method,
+			})
+		}
+	}
+	return nil
+}
+
+func (c *JSONRPCClient) split(ctx context.Context, elems []BatchElem) error {
+	half := len(elems) / 2
+	if err := c.send(ctx, elems[:half]); err != nil {
+		return err
+	}
+	return c.send(ctx, elems[half:])
+}
+
+func rpcError(traceID string, e *RPCError, method string) error {
+	return syserror.NewV2(traceID, ErrIDJSONRPC, e.Message, syserror.WithFields(map[string]interface{}{
+		"JSONRPCCode": e.Code,
+		"JSONRPCData": string(e.Data),
+		"Method":      method,
+	}))
+}
+
+// batchSizeErrors messages of providers rejecting a batch for its size.
+var batchSizeErrors = []string{"too large", "too big", "batch size", "batch limit", "size limit"}
+
+// isBatchSizeError reports whether e rejects the size of a batch, splitting it may succeed.
+func isBatchSizeError(e *RPCError) bool {
+	msg := strings.ToLower(e.Message)
+	for _, s := range batchSizeErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// JSONRPCErrorCode returns the json-rpc error code carried by err.
+func JSONRPCErrorCode(err error) (int, bool) {
+	e, ok := err.(*syserror.SysError)
+	if !ok || e.ID != ErrIDJSONRPC {
+		return 0, false
+	}
+	code, ok := e.MemoryValues["JSONRPCCode"].(int)
+	return code, ok
+}
+
+// isStatusCode reports whether err is an unexpected status code error of the given code.
+func isStatusCode(err error, code int) bool {
+	httpErr, ok := AsHTTPError(err)
+	return ok && httpErr.StatusCode == code
+}
@@ -0,0 +1,176 @@
+package httplib_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+type rpcReq struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// newRPCServer echoes the first param of each call, method "fail" answers an error object.
+// Batches larger than maxBatch are rejected with 413.
+func newRPCServer(t *testing.T, maxBatch int, sizes *[]int) *httptest.Server {
+	answer := func(req rpcReq) map[string]interface{} {
+		rsp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if req.Method == "fail" {
+			rsp["error"] = map[string]interface{}{"code": -32000, "message": "execution reverted", "data": "0x01"}
+		} else if len(req.Params) > 0 {
+			rsp["result"] = req.Params[0]
+		} else {
+			rsp["result"] = nil
+		}
+		return rsp
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// require must not be called outside of the test goroutine, fail the request instead
+		if r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected content type", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var batch []rpcReq
+		if err := json.Unmarshal(body, &batch); err != nil {
+			var req rpcReq
+			if err := json.Unmarshal(body, &req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			*sizes = append(*sizes, 1)
+			json.NewEncoder(w).Encode(answer(req))
+			return
+		}
+		*sizes = append(*sizes, len(batch))
+		if maxBatch > 0 && len(batch) > maxBatch {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		rsps := make([]map[string]interface{}, 0, len(batch))
+		// answer out of order
+		for i := len(batch) - 1; i >= 0; i-- {
+			rsps = append(rsps, answer(batch[i]))
+		}
+		json.NewEncoder(w).Encode(rsps)
+	}))
+}
+
+func TestJSONRPCClient_Call(t *testing.T) {
+	var sizes []int
+	srv := newRPCServer(t, 0, &sizes)
+	defer srv.Close()
+	client := httplib.NewJSONRPCClient(httplib.NewHTTPClient(), srv.URL)
+
+	var block string
+	require.NoError(t, client.Call(context.Background(), &block, "eth_blockNumber", "0x10"))
+	require.Equal(t, "0x10", block)
+
+	err := client.Call(context.Background(), nil, "fail")
+	require.Error(t, err)
+	code, ok := httplib.JSONRPCErrorCode(err)
+	require.True(t, ok)
+	require.Equal(t, -32000, code)
+}
+
+func TestJSONRPCClient_BatchCall(t *testing.T) {
+	var sizes []int
+	srv := newRPCServer(t, 0, &sizes)
+	defer srv.Close()
+	client := httplib.NewJSONRPCClient(httplib.NewHTTPClient(), srv.URL).SetMaxBatchSize(3)
+
+	results := make([]int, 5)
+	elems := make([]httplib.BatchElem, 0, 6)
+	for i := range results {
+		elems = append(elems, httplib.BatchElem{Method: "echo", Params: []interface{}{i * 10}, Result: &results[i]})
+	}
+	elems = append(elems, httplib.BatchElem{Method: "fail"})
+	require.NoError(t, client.BatchCall(context.Background(), elems))
+	require.Equal(t, []int{3, 3}, sizes)
+	require.Equal(t, []int{0, 10, 20, 30, 40}, results)
+	for _, elem := range elems[:5] {
+		require.NoError(t, elem.Error)
+	}
+	_, ok := httplib.JSONRPCErrorCode(elems[5].Error)
+	require.True(t, ok)
+}
+
+func TestJSONRPCClient_SplitTooLarge(t *testing.T) {
+	var sizes []int
+	srv := newRPCServer(t, 2, &sizes)
+	defer srv.Close()
+	client := httplib.NewJSONRPCClient(httplib.NewHTTPClient(), srv.URL)
+
+	results := make([]int, 4)
+	elems := make([]httplib.BatchElem, len(results))
+	for i := range elems {
+		elems[i] = httplib.BatchElem{Method: "echo", Params: []interface{}{i + 1}, Result: &results[i]}
+	}
+	require.NoError(t, client.BatchCall(context.Background(), elems))
+	require.Equal(t, []int{4, 2, 2}, sizes)
+	require.Equal(t, []int{1, 2, 3, 4}, results)
+}
+
+func TestJSONRPCClient_BatchError(t *testing.T) {
+	var sizes []int
+	message := "batch size too large"
+	// batches of more than 2 calls are answered with a single error object
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []rpcReq
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sizes = append(sizes, len(batch))
+		if len(batch) > 2 {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      nil,
+				"error":   map[string]interface{}{"code": -32005, "message": message},
+			})
+			return
+		}
+		rsps := make([]map[string]interface{}, 0, len(batch))
+		for _, req := range batch {
+			rsps = append(rsps, map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": req.Params[0]})
+		}
+		json.NewEncoder(w).Encode(rsps)
+	}))
+	defer srv.Close()
+	client := httplib.NewJSONRPCClient(httplib.NewHTTPClient(), srv.URL)
+	newElems := func(results []int) []httplib.BatchElem {
+		elems := make([]httplib.BatchElem, len(results))
+		for i := range elems {
+			elems[i] = httplib.BatchElem{Method: "echo", Params: []interface{}{i + 1}, Result: &results[i]}
+		}
+		return elems
+	}
+
+	// a size error splits the batch
+	results := make([]int, 4)
+	require.NoError(t, client.BatchCall(context.Background(), newElems(results)))
+	require.Equal(t, []int{4, 2, 2}, sizes)
+	require.Equal(t, []int{1, 2, 3, 4}, results)
+
+	// any other error fails every call of the batch
+	sizes, message = nil, "rate limit exceeded"
+	results = make([]int, 4)
+	elems := newElems(results)
+	require.NoError(t, client.BatchCall(context.Background(), elems))
+	require.Equal(t, []int{4}, sizes)
+	for _, elem := range elems {
+		code, ok := httplib.JSONRPCErrorCode(elem.Error)
+		require.True(t, ok)
+		require.Equal(t, -32005, code)
+	}
+	require.Equal(t, []int{0, 0, 0, 0}, results)
+}
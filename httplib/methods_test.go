@@ -0,0 +1,63 @@
+package httplib_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpMethods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "42")
+			return
+		}
+		w.Write([]byte(`{"method":"` + r.Method + `","body":"` + string(body) + `"}`))
+	}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient()
+	ctx := context.Background()
+
+	type echo struct {
+		Method string `json:"method"`
+		Body   string `json:"body"`
+	}
+	for _, tc := range []struct {
+		method string
+		send   func(ctx context.Context, args *httplib.RequestArgs) error
+	}{
+		{http.MethodGet, client.Get},
+		{http.MethodDelete, client.Delete},
+		{http.MethodPost, client.Post},
+		{http.MethodPut, client.Put},
+		{http.MethodPatch, client.Patch},
+		{http.MethodOptions, client.Options},
+		{"PURGE", func(ctx context.Context, args *httplib.RequestArgs) error { return client.Do(ctx, "PURGE", args) }},
+	} {
+		var result echo
+		err := tc.send(ctx, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, Body: []byte("payload"), JSONResult: &result})
+		require.NoError(t, err, tc.method)
+		require.Equal(t, echo{Method: tc.method, Body: "payload"}, result)
+	}
+
+	// HEAD fills the headers and leaves the results alone
+	headers := map[string][]string{}
+	buf := bytes.NewBufferString("untouched")
+	err := client.Head(ctx, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, Body: []byte("ignored"), ResponseHeaders: headers, BytesResult: buf})
+	require.NoError(t, err)
+	require.Equal(t, []string{http.MethodHead}, headers["X-Method"])
+	require.Equal(t, []string{"42"}, headers["Content-Length"])
+	require.Equal(t, "untouched", buf.String())
+
+	err = client.Do(ctx, "BAD METHOD", &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL})
+	require.Error(t, err)
+}
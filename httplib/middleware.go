@@ -0,0 +1,160 @@
+package httplib
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/DeBankDeFi/golib/util"
+
+	log "github.com/DeBankDeFi/glog"
+	"github.com/google/uuid"
+)
+
+// Default header names of the built-in middlewares.
+const (
+	DefaultTraceIDHeader   = "X-Trace-Id"
+	DefaultRequestIDHeader = "X-Request-Id"
+)
+
+// RoundTripperFunc adapts a function to http.RoundTripper.
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip ...
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps the round tripper of an HTTPClient, it sees every attempt of every request
+// along with its response or error. A middleware must not modify the request it receives,
+// clone it before changing headers.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// Use 添加中间件, 先添加的中间件在外层
+func (c *HTTPClient) Use(mws ...Middleware) *HTTPClient {
+	c.middlewares = append(c.middlewares, mws...)
+	return c
+}
+
+// chain wraps rt with the middlewares of the client.
+func (c *HTTPClient) chain(rt http.RoundTripper) http.RoundTripper {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		if c.middlewares[i] != nil {
+			rt = c.middlewares[i](rt)
+		}
+	}
+	return rt
+}
+
+// setHeader returns a clone of req with the header set.
+func setHeader(req *http.Request, key, value string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set(key, value)
+	return req
+}
+
+// LoggingMiddleware logs method, url, status and latency of each attempt.
+func LoggingMiddleware(logger log.Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			rsp, err := next.RoundTrip(req)
+			ctx := req.Context()
+			if tid := util.GetTraceIDFromContext(ctx); tid != "" {
+				ctx = log.WithTraceId(ctx, tid)
+			}
+			if err != nil {
+				logger.Warnf(ctx, "httplib: %s %s failed after %v, reason: %v", req.Method, redactURL(req), time.Since(start), err.Error())
+				return rsp, err
+			}
+			logger.Infof(ctx, "httplib: %s %s %d in %v", req.Method, redactURL(req), rsp.StatusCode, time.Since(start))
+			return rsp, err
+		})
+	}
+}
+
+// redactURL drops the query which may carry credentials.
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
+
+// RequestMetrics describes one attempt of a request.
+type RequestMetrics struct {
+	Method     string
+	Host       string
+	Path       string
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+// MetricsMiddleware reports each attempt to observe, e.g. to update prometheus collectors.
+func MetricsMiddleware(observe func(m *RequestMetrics)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			start := time.Now()
+			rsp, err := next.RoundTrip(req)
+			m := &RequestMetrics{
+				Method:   req.Method,
+				Host:     req.URL.Host,
+				Path:     req.URL.Path,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+			if rsp != nil {
+				m.StatusCode = rsp.StatusCode
+			}
+			observe(m)
+			return rsp, err
+		})
+	}
+}
+
+// TraceIDMiddleware sends the trace id of the request context in header, DefaultTraceIDHeader if empty.
+// Clients can propagate the trace id on their own (see SetTracePropagation), the middleware is useful
+// for a downstream which expects it in an additional header.
+func TraceIDMiddleware(header string) Middleware {
+	if header == "" {
+		header = DefaultTraceIDHeader
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if tid := util.GetTraceIDFromContext(req.Context()); tid != "" && req.Header.Get(header) == "" {
+				req = setHeader(req, header, tid)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// AuthTokenMiddleware sends a bearer token obtained from token in the Authorization header.
+func AuthTokenMiddleware(token func(ctx context.Context) (string, error)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			tok, err := token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(setHeader(req, "Authorization", "Bearer "+tok))
+		})
+	}
+}
+
+// RequestIDMiddleware generates a unique id in header for each attempt which does not have one,
+// DefaultRequestIDHeader if empty.
+func RequestIDMiddleware(header string) Middleware {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(header) == "" {
+				req = setHeader(req, header, uuid.New().String())
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package httplib_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/util"
+
+	log "github.com/DeBankDeFi/glog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewares(t *testing.T) {
+	var received http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	var order []string
+	tag := func(name string) httplib.Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return httplib.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+">")
+				rsp, err := next.RoundTrip(req)
+				order = append(order, "<"+name)
+				return rsp, err
+			})
+		}
+	}
+	var metrics []*httplib.RequestMetrics
+	client := httplib.NewHTTPClient().Use(
+		tag("outer"),
+		tag("inner"),
+		httplib.LoggingMiddleware(log.NewLogFactory().DefaultLogger(log.LevelInfo)),
+		httplib.MetricsMiddleware(func(m *httplib.RequestMetrics) { metrics = append(metrics, m) }),
+		httplib.TraceIDMiddleware(""),
+		httplib.RequestIDMiddleware(""),
+		httplib.AuthTokenMiddleware(func(ctx context.Context) (string, error) { return "secret", nil }),
+	)
+
+	ctx := util.SetTraceIDToContext(context.Background(), "tid-1")
+	err := client.Get(ctx, &httplib.RequestArgs{
+		TraceID:            "tid-1",
+		URL:                srv.URL + "/path",
+		ExpectedStatusCode: []int{http.StatusCreated},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"outer>", "inner>", "<inner", "<outer"}, order)
+	require.Equal(t, "tid-1", received.Get(httplib.DefaultTraceIDHeader))
+	require.NotEmpty(t, received.Get(httplib.DefaultRequestIDHeader))
+	require.Equal(t, "Bearer secret", received.Get("Authorization"))
+	require.Len(t, metrics, 1)
+	require.Equal(t, http.StatusCreated, metrics[0].StatusCode)
+	require.Equal(t, "/path", metrics[0].Path)
+}
+
+func TestMiddlewares_Error(t *testing.T) {
+	var metrics []*httplib.RequestMetrics
+	client := httplib.NewHTTPClient().Use(
+		httplib.MetricsMiddleware(func(m *httplib.RequestMetrics) { metrics = append(metrics, m) }),
+		httplib.AuthTokenMiddleware(func(ctx context.Context) (string, error) { return "", errors.New("no token") }),
+	)
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "http://127.0.0.1:1"})
+	require.Error(t, err)
+	require.Len(t, metrics, 1)
+	require.Error(t, metrics[0].Err)
+}
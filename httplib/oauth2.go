@@ -0,0 +1,279 @@
+package httplib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ErrIDTokenSource syserror ID of requests whose token could not be obtained.
+const ErrIDTokenSource = "HTTP_TOKEN_SOURCE"
+
+// Token an OAuth2 access token.
+type Token struct {
+	AccessToken string
+	// TokenType default Bearer.
+	TokenType string
+	// Expiry zero means the token does not expire.
+	Expiry time.Time
+}
+
+// Valid reports whether the token is set and not expired.
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Before(t.Expiry))
+}
+
+// authorization returns the value of the Authorization header.
+func (t *Token) authorization() string {
+	typ := t.TokenType
+	if typ == "" || strings.EqualFold(typ, "bearer") {
+		typ = "Bearer"
+	}
+	return typ + " " + t.AccessToken
+}
+
+// TokenSource returns tokens, see ClientCredentials and CachedTokenSource. A source may
+// implement CacheIdentifier, so that cached responses survive token rotation.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// ClientCredentials fetches tokens from an OAuth2 token endpoint with the client credentials
+// grant. Wrap it in a CachedTokenSource, SetTokenSource does so.
+type ClientCredentials struct {
+	// TokenURL token endpoint, required.
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams additional form parameters, e.g. audience.
+	EndpointParams url.Values
+	// AuthInParams sends the credentials in the form instead of a basic auth header.
+	AuthInParams bool
+
+	// Client sends the token requests, default NewHTTPClient().
+	Client *HTTPClient
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type tokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// CacheIdentity identifies the token endpoint, client, scopes and parameters, see CacheIdentifier.
+func (cc *ClientCredentials) CacheIdentity() string {
+	scopes := append([]string(nil), cc.Scopes...)
+	sort.Strings(scopes)
+	return "oauth2 " + sha256Hex([]byte(cc.TokenURL+"\n"+cc.ClientID+"\n"+strings.Join(scopes, " ")+"\n"+cc.EndpointParams.Encode()))
+}
+
+// Token requests a new token.
+func (cc *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.Scopes) > 0 {
+		form.Set("scope", strings.Join(cc.Scopes, " "))
+	}
+	for k, values := range cc.EndpointParams {
+		form[k] = values
+	}
+	args := &RequestArgs{
+		URL:         cc.TokenURL,
+		Body:        form,
+		ErrorResult: &tokenError{},
+	}
+	if cc.AuthInParams {
+		form.Set("client_id", cc.ClientID)
+		form.Set("client_secret", cc.ClientSecret)
+	} else {
+		args.BasicAuth = &BasicAuth{Username: url.QueryEscape(cc.ClientID), Password: url.QueryEscape(cc.ClientSecret)}
+	}
+	client := cc.Client
+	if client == nil {
+		client = NewHTTPClient()
+	}
+
+	var rsp tokenResponse
+	args.JSONResult = &rsp
+	if err := client.Post(ctx, args); err != nil {
+		if httpErr, ok := AsHTTPError(err); ok {
+			if e, ok := httpErr.ErrorResult.(*tokenError); ok && e.Error != "" {
+				return nil, fmt.Errorf("oauth2: %s %s", e.Error, e.ErrorDescription)
+			}
+		}
+		return nil, err
+	}
+	if rsp.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2: token endpoint %s returned no access_token", cc.TokenURL)
+	}
+	token := &Token{AccessToken: rsp.AccessToken, TokenType: rsp.TokenType}
+	if rsp.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(rsp.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// CachedTokenSource caches the token of a source. Tokens are refreshed in the background
+// before they expire, and concurrent callers share a single refresh.
+type CachedTokenSource struct {
+	src           TokenSource
+	refreshBefore time.Duration
+
+	mu      sync.Mutex
+	token   *Token
+	fetched time.Time
+	flight  *tokenFlight
+	// retryAt delays the next background refresh after a failed one
+	retryAt time.Time
+}
+
+type tokenFlight struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// tokenRefreshRetry delay between failed background refreshes.
+const tokenRefreshRetry = 5 * time.Second
+
+// NewCachedTokenSource caches the tokens of src and refreshes them refreshBefore they expire,
+// default 1 minute. Short-lived tokens are refreshed at half of their lifetime at the latest.
+func NewCachedTokenSource(src TokenSource, refreshBefore time.Duration) *CachedTokenSource {
+	if refreshBefore <= 0 {
+		refreshBefore = time.Minute
+	}
+	return &CachedTokenSource{src: src, refreshBefore: refreshBefore}
+}
+
+// Token returns the cached token, it waits for a new one only if the cached token expired
+// or was invalidated.
+func (s *CachedTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	if s.token.Valid() {
+		token := s.token
+		if s.flight == nil && s.needsRefresh(time.Now()) {
+			s.refreshLocked()
+		}
+		s.mu.Unlock()
+		return token, nil
+	}
+	f := s.flight
+	if f == nil {
+		f = s.refreshLocked()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CacheIdentity returns the identity of the wrapped source, empty if it has none.
+func (s *CachedTokenSource) CacheIdentity() string {
+	if id, ok := s.src.(CacheIdentifier); ok {
+		return id.CacheIdentity()
+	}
+	return ""
+}
+
+// Invalidate drops token if it is still cached, e.g. after the server rejected it, so that
+// the next call fetches a new one.
+func (s *CachedTokenSource) Invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = nil
+	}
+}
+
+func (s *CachedTokenSource) needsRefresh(now time.Time) bool {
+	if s.token.Expiry.IsZero() || now.Before(s.retryAt) {
+		return false
+	}
+	before := s.refreshBefore
+	if half := s.token.Expiry.Sub(s.fetched) / 2; half < before {
+		before = half
+	}
+	return !now.Before(s.token.Expiry.Add(-before))
+}
+
+// refreshLocked fetches a token in the background, it is not bound to the context of a
+// caller so that a canceled caller does not fail the others.
+func (s *CachedTokenSource) refreshLocked() *tokenFlight {
+	f := &tokenFlight{done: make(chan struct{})}
+	s.flight = f
+	go func() {
+		token, err := s.src.Token(context.Background())
+		if err == nil && !token.Valid() {
+			err = errors.New("oauth2: token source returned an invalid token")
+		}
+		s.mu.Lock()
+		if err == nil {
+			s.token, s.fetched = token, time.Now()
+		} else {
+			s.retryAt = time.Now().Add(tokenRefreshRetry)
+		}
+		f.token, f.err = token, err
+		s.flight = nil
+		s.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// SetTokenSource 设置OAuth2 token来源, 每次请求携带Authorization头, 收到401时换新token向同一后端重试一次
+// 启用响应缓存时, 实现CacheIdentifier的token来源按其身份而不是token缓存, token轮换不会使缓存失效
+func (c *HTTPClient) SetTokenSource(ts TokenSource) *HTTPClient {
+	cached, ok := ts.(*CachedTokenSource)
+	if !ok && ts != nil {
+		cached = NewCachedTokenSource(ts, 0)
+	}
+	c.tokens = cached
+	return c
+}
+
+// authorize sets the token of the client on req and returns it.
+func (c *HTTPClient) authorize(req *http.Request, args *RequestArgs) (*Token, error) {
+	if c.tokens == nil {
+		return nil, nil
+	}
+	token, err := c.tokens.Token(req.Context())
+	if err != nil {
+		fields := map[string]interface{}{"URL": req.URL.String()}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, requestError(req, args, ErrIDTokenSource, err, fields)
+		}
+		return nil, syserror.NewV2(args.TraceID, ErrIDTokenSource, err.Error(), syserror.WithCode(codes.Unauthenticated),
+			syserror.WithCause(err), syserror.WithFields(fields))
+	}
+	req.Header.Set("Authorization", token.authorization())
+	return token, nil
+}
+
+// reauthorize drops a token rejected with 401, it reports whether the request should be sent
+// again with a new token.
+func (c *HTTPClient) reauthorize(token *Token, rsp *http.Response) bool {
+	if token == nil || rsp == nil || rsp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	c.tokens.Invalidate(token)
+	return true
+}
@@ -0,0 +1,230 @@
+package httplib_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenServer issues tok-1, tok-2, ... to client id/secret, slowly so that concurrent
+// callers overlap.
+func newTokenServer(t *testing.T, fetches *int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "id" || secret != "secret" || r.PostFormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client", "error_description": "bad credentials"})
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		n := atomic.AddInt32(fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("tok-%d", n),
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTokenSource_ClientCredentials(t *testing.T) {
+	var fetches int32
+	tokenSrv := newTokenServer(t, &fetches)
+	// the api rejects the first token, e.g. it was revoked
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer tok-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	client := httplib.NewHTTPClient().SetTokenSource(&httplib.ClientCredentials{
+		TokenURL:     tokenSrv.URL,
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"read"},
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp, err := client.NewRequest(http.MethodGet, api.URL).TraceID("fakeID").Do(context.Background())
+			if err == nil && string(rsp.Value) != "Bearer tok-2" {
+				err = fmt.Errorf("unexpected token %s", rsp.Value)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	// one fetch for the first token, one after it was rejected
+	require.EqualValues(t, 2, atomic.LoadInt32(&fetches))
+
+	// a token which is rejected again fails the request after one retry
+	client = httplib.NewHTTPClient().SetTokenSource(&httplib.ClientCredentials{TokenURL: tokenSrv.URL, ClientID: "id", ClientSecret: "secret"})
+	atomic.StoreInt32(&fetches, 0)
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer reject.Close()
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: reject.URL})
+	httpErr, ok := httplib.AsHTTPError(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+	require.EqualValues(t, 2, atomic.LoadInt32(&fetches))
+}
+
+func TestTokenSource_Error(t *testing.T) {
+	var fetches int32
+	tokenSrv := newTokenServer(t, &fetches)
+	client := httplib.NewHTTPClient().SetTokenSource(&httplib.ClientCredentials{TokenURL: tokenSrv.URL, ClientID: "id", ClientSecret: "wrong"})
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "http://127.0.0.1:1"})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDTokenSource, err.(*syserror.SysError).ID)
+	require.Contains(t, err.Error(), "invalid_client bad credentials")
+}
+
+type countingSource struct {
+	lifetime time.Duration
+	fetches  int32
+}
+
+func (s *countingSource) Token(ctx context.Context) (*httplib.Token, error) {
+	n := atomic.AddInt32(&s.fetches, 1)
+	return &httplib.Token{AccessToken: fmt.Sprintf("tok-%d", n), Expiry: time.Now().Add(s.lifetime)}, nil
+}
+
+func TestCachedTokenSource_Refresh(t *testing.T) {
+	src := &countingSource{lifetime: 200 * time.Millisecond}
+	ts := httplib.NewCachedTokenSource(src, time.Minute)
+	ctx := context.Background()
+
+	token, err := ts.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "tok-1", token.AccessToken)
+	token, err = ts.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "tok-1", token.AccessToken)
+
+	// past half of the lifetime the cached token is returned and refreshed in the background
+	time.Sleep(120 * time.Millisecond)
+	token, err = ts.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "tok-1", token.AccessToken)
+	require.Eventually(t, func() bool {
+		token, err := ts.Token(ctx)
+		return err == nil && token.AccessToken == "tok-2"
+	}, time.Second, 5*time.Millisecond)
+
+	ts.Invalidate(token)
+	token, err = ts.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "tok-2", token.AccessToken, "an outdated token does not invalidate the cached one")
+	ts.Invalidate(&httplib.Token{AccessToken: "tok-2"})
+	current, _ := ts.Token(ctx)
+	ts.Invalidate(current)
+	token, err = ts.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "tok-3", token.AccessToken)
+	require.EqualValues(t, 3, atomic.LoadInt32(&src.fetches))
+}
+
+func TestTokenSource_ReauthSameEndpoint(t *testing.T) {
+	// the first endpoint rejects the first token, the retry with a new token goes to it again
+	var hitsA, hitsB int32
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsA, 1)
+		if r.Header.Get("Authorization") == "Bearer tok-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hitsB, 1)
+	}))
+	defer b.Close()
+	pool, err := httplib.NewEndpointPool(httplib.EndpointPoolConfig{Endpoints: []httplib.Endpoint{{URL: a.URL}, {URL: b.URL}}})
+	require.NoError(t, err)
+	client := httplib.NewHTTPClient().SetEndpointPool(pool).SetTokenSource(&countingSource{lifetime: time.Hour})
+
+	require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: "/"}))
+	require.EqualValues(t, 2, atomic.LoadInt32(&hitsA))
+	require.EqualValues(t, 0, atomic.LoadInt32(&hitsB))
+}
+
+// identifiedSource a token source whose credentials are identified by id.
+type identifiedSource struct {
+	countingSource
+	id string
+}
+
+func (s *identifiedSource) CacheIdentity() string { return s.id }
+
+func TestTokenSource_CacheRotation(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "httplib-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	newClient := func(src httplib.TokenSource) *httplib.HTTPClient {
+		// a new store per client stands for a restarted process sharing the cache directory
+		store, err := httplib.NewDiskCacheStore(dir)
+		require.NoError(t, err)
+		return httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: store}).SetTokenSource(src)
+	}
+	get := func(client *httplib.HTTPClient) {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}))
+	}
+
+	// tokens expire right away, every request uses a new one
+	src := &identifiedSource{countingSource: countingSource{lifetime: time.Millisecond}, id: "client-a"}
+	client := newClient(src)
+	get(client)
+	time.Sleep(5 * time.Millisecond)
+	get(client)
+	require.EqualValues(t, 2, atomic.LoadInt32(&src.fetches))
+	require.EqualValues(t, 1, atomic.LoadInt32(&hits), "a rotated token keeps the cached response")
+
+	// the identity survives a restart, other credentials do not share the responses
+	get(newClient(&identifiedSource{countingSource: countingSource{lifetime: time.Hour}, id: "client-a"}))
+	require.EqualValues(t, 1, atomic.LoadInt32(&hits))
+	get(newClient(&identifiedSource{countingSource: countingSource{lifetime: time.Hour}, id: "client-b"}))
+	require.EqualValues(t, 2, atomic.LoadInt32(&hits))
+
+	// sources without an identity are keyed by their token
+	get(newClient(&countingSource{lifetime: time.Hour}))
+	require.EqualValues(t, 3, atomic.LoadInt32(&hits))
+
+	cc := &httplib.ClientCredentials{TokenURL: "https://auth.example/token", ClientID: "id", Scopes: []string{"read", "write"}}
+	same := &httplib.ClientCredentials{TokenURL: "https://auth.example/token", ClientID: "id", ClientSecret: "rotated", Scopes: []string{"write", "read"}}
+	other := &httplib.ClientCredentials{TokenURL: "https://auth.example/token", ClientID: "id", Scopes: []string{"read"}}
+	require.Equal(t, cc.CacheIdentity(), same.CacheIdentity())
+	require.NotEqual(t, cc.CacheIdentity(), other.CacheIdentity())
+}
@@ -0,0 +1,220 @@
+package httplib
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"google.golang.org/grpc/codes"
+)
+
+// ErrIDRateLimited the wait for a token of the rate limit exceeded RateLimitConfig.MaxWait or
+// the deadline of the request context, the request was not sent.
+const ErrIDRateLimited = "HTTP_RATE_LIMITED"
+
+// RateLimit a token bucket.
+type RateLimit struct {
+	// Rate tokens added per second.
+	Rate float64
+	// Burst capacity of the bucket, defaults to the rate rounded up.
+	Burst int
+}
+
+// RateLimitConfig outbound rate limits of an HTTPClient, every attempt of a request takes a token.
+type RateLimitConfig struct {
+	// Default limit of every host without an entry in Hosts, a zero Rate disables it.
+	Default RateLimit
+	// Hosts limits by host, e.g. "api.partner.com" or "api.partner.com:8443".
+	Hosts map[string]RateLimit
+	// Keys named limits of requests with RequestArgs.RateLimitKey, e.g. one per api key.
+	Keys map[string]RateLimit
+	// MaxWait longest wait for a token, 0 waits as long as the request context allows.
+	MaxWait time.Duration
+}
+
+// RateLimitStats snapshot of a bucket.
+type RateLimitStats struct {
+	// Name host or key of the bucket.
+	Name   string
+	Rate   float64
+	Burst  int
+	Tokens float64
+	// Waiting requests waiting for a token.
+	Waiting int
+	// Allowed and Rejected count attempts by outcome.
+	Allowed  uint64
+	Rejected uint64
+	// Waited total time attempts waited for tokens.
+	Waited time.Duration
+}
+
+// SetRateLimit 设置按host或按key的令牌桶限流, 对所有请求生效
+func (c *HTTPClient) SetRateLimit(cfg RateLimitConfig) *HTTPClient {
+	c.limiter = &rateLimiter{cfg: cfg, buckets: make(map[string]*tokenBucket)}
+	return c
+}
+
+// RateLimitStats returns a snapshot of the buckets in use, sorted by name.
+func (c *HTTPClient) RateLimitStats() []RateLimitStats {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.stats()
+}
+
+type rateLimiter struct {
+	cfg RateLimitConfig
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// bucket returns the bucket of a request, nil if it is not limited.
+func (l *rateLimiter) bucket(host, key string) *tokenBucket {
+	name, limit := host, l.cfg.Default
+	if lim, ok := l.cfg.Keys[key]; ok && key != "" {
+		name, limit = "key:"+key, lim
+	} else if lim, ok := l.cfg.Hosts[host]; ok {
+		limit = lim
+	}
+	if limit.Rate <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[name]
+	if !ok {
+		b = newTokenBucket(name, limit)
+		l.buckets[name] = b
+	}
+	return b
+}
+
+// wait blocks until the attempt may be sent.
+func (l *rateLimiter) wait(ctx context.Context, args *RequestArgs, host string) error {
+	b := l.bucket(host, args.RateLimitKey)
+	if b == nil {
+		return nil
+	}
+	maxWait := l.cfg.MaxWait
+	if deadline, ok := ctx.Deadline(); ok {
+		if until := time.Until(deadline); maxWait <= 0 || until < maxWait {
+			maxWait = until
+		}
+	}
+	wait, ok := b.reserve(time.Now(), maxWait)
+	if !ok {
+		return syserror.NewV2(args.TraceID, ErrIDRateLimited, "rate limit wait exceeds the limit", syserror.WithCode(codes.ResourceExhausted), syserror.WithFields(map[string]interface{}{
+			"Bucket":  b.name,
+			"Wait":    wait.String(),
+			"MaxWait": maxWait.String(),
+		}))
+	}
+	if wait <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	b.waiting++
+	b.mu.Unlock()
+	err := sleepContext(ctx, wait)
+	b.mu.Lock()
+	b.waiting--
+	if err != nil {
+		// give the token back to later requests
+		b.tokens = math.Min(b.tokens+1, float64(b.burst))
+		b.allowed--
+		b.rejected++
+		b.waited -= wait
+	}
+	b.mu.Unlock()
+	return err
+}
+
+func (l *rateLimiter) stats() []RateLimitStats {
+	l.mu.Lock()
+	buckets := make([]*tokenBucket, 0, len(l.buckets))
+	for _, b := range l.buckets {
+		buckets = append(buckets, b)
+	}
+	l.mu.Unlock()
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].name < buckets[j].name })
+
+	now := time.Now()
+	stats := make([]RateLimitStats, len(buckets))
+	for i, b := range buckets {
+		b.mu.Lock()
+		b.refillLocked(now)
+		stats[i] = RateLimitStats{
+			Name:     b.name,
+			Rate:     b.rate,
+			Burst:    b.burst,
+			Tokens:   b.tokens,
+			Waiting:  b.waiting,
+			Allowed:  b.allowed,
+			Rejected: b.rejected,
+			Waited:   b.waited,
+		}
+		b.mu.Unlock()
+	}
+	return stats
+}
+
+type tokenBucket struct {
+	name  string
+	rate  float64
+	burst int
+
+	mu sync.Mutex
+	// tokens negative while attempts wait for reserved tokens
+	tokens   float64
+	last     time.Time
+	waiting  int
+	allowed  uint64
+	rejected uint64
+	waited   time.Duration
+}
+
+func newTokenBucket(name string, limit RateLimit) *tokenBucket {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{
+		name:   name,
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refillLocked(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*b.rate, float64(b.burst))
+		b.last = now
+	}
+}
+
+// reserve takes a token and returns how long to wait until it is available, the token is
+// not taken if the wait would exceed maxWait.
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(now)
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if wait > 0 && maxWait != 0 && wait > maxWait {
+		b.rejected++
+		return wait, false
+	}
+	b.tokens--
+	b.allowed++
+	b.waited += wait
+	return wait, true
+}
@@ -0,0 +1,94 @@
+package httplib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit_Host(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host := mustHost(t, srv.URL)
+	client := httplib.NewHTTPClient().SetRateLimit(httplib.RateLimitConfig{
+		Hosts: map[string]httplib.RateLimit{host: {Rate: 20, Burst: 2}},
+	})
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}))
+	}
+	// two requests use the burst, four wait 50ms each
+	require.True(t, time.Since(start) >= 150*time.Millisecond, time.Since(start).String())
+	stats := client.RateLimitStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, host, stats[0].Name)
+	require.EqualValues(t, 6, stats[0].Allowed)
+	require.True(t, stats[0].Waited > 0)
+}
+
+func TestRateLimit_MaxWait(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetRateLimit(httplib.RateLimitConfig{
+		Default: httplib.RateLimit{Rate: 1},
+		MaxWait: 10 * time.Millisecond,
+	})
+	require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}))
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDRateLimited, err.(*syserror.SysError).ID)
+	require.EqualValues(t, 1, client.RateLimitStats()[0].Rejected)
+}
+
+func TestRateLimit_Context(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetRateLimit(httplib.RateLimitConfig{
+		Keys: map[string]httplib.RateLimit{"partner": {Rate: 2}},
+	})
+	// requests without the key are not limited
+	for i := 0; i < 5; i++ {
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}))
+	}
+
+	args := func() *httplib.RequestArgs {
+		return &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, RateLimitKey: "partner"}
+	}
+	require.NoError(t, client.Get(context.Background(), args()))
+	require.NoError(t, client.Get(context.Background(), args()))
+
+	// a deadline shorter than the wait fails right away
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Get(ctx, args())
+	require.Equal(t, httplib.ErrIDRateLimited, err.(*syserror.SysError).ID)
+	require.True(t, time.Since(start) < 40*time.Millisecond)
+
+	// canceling a waiting request
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = client.Get(ctx, args())
+	require.Equal(t, httplib.ErrIDRequestCanceled, err.(*syserror.SysError).ID)
+	stats := client.RateLimitStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "key:partner", stats[0].Name)
+	require.EqualValues(t, 2, stats[0].Allowed)
+	require.EqualValues(t, 2, stats[0].Rejected)
+	require.Zero(t, stats[0].Waiting)
+}
+
+func mustHost(t *testing.T, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u.Host
+}
@@ -0,0 +1,339 @@
+package httplib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// RecorderMode how a Recorder treats requests.
+type RecorderMode int
+
+const (
+	// ModeReplay answers requests from the golden file.
+	ModeReplay RecorderMode = iota
+	// ModeRecord sends requests to the network and rewrites the golden file with them.
+	ModeRecord
+)
+
+// Redacted replaces the values of redacted headers in golden files.
+const Redacted = "REDACTED"
+
+// RecordedRequest a request of a golden file.
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	// BodyBase64 the body is base64 encoded as it is not utf-8.
+	BodyBase64 bool `json:"body_base64,omitempty"`
+}
+
+// RecordedResponse a response of a golden file.
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+// Interaction a request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// Matcher reports whether a request matches a recorded one.
+type Matcher func(req *http.Request, body []byte, recorded *RecordedRequest) bool
+
+// MatchMethod matches the method.
+func MatchMethod(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+	return req.Method == recorded.Method
+}
+
+// MatchURL matches scheme, host and path.
+func MatchURL(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+	u, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	return req.URL.Scheme == u.Scheme && req.URL.Host == u.Host && req.URL.Path == u.Path
+}
+
+// MatchQuery matches the query parameters regardless of their order.
+func MatchQuery(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+	u, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+	want, got := u.Query(), req.URL.Query()
+	if len(want) == 0 && len(got) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+// MatchBody matches the body, json bodies are compared semantically.
+func MatchBody(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+	want, err := decodeRecordedBody(recorded.Body, recorded.BodyBase64)
+	if err != nil {
+		return false
+	}
+	if bytes.Equal(want, body) {
+		return true
+	}
+	var a, b interface{}
+	if json.Unmarshal(want, &a) != nil || json.Unmarshal(body, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// MatchHeader matches the value of a header.
+func MatchHeader(name string) Matcher {
+	return func(req *http.Request, body []byte, recorded *RecordedRequest) bool {
+		return req.Header.Get(name) == recorded.Header.Get(name)
+	}
+}
+
+// DefaultMatchers match method, url, query and body.
+var DefaultMatchers = []Matcher{MatchMethod, MatchURL, MatchQuery, MatchBody}
+
+// RecorderConfig configuration of a Recorder.
+type RecorderConfig struct {
+	// Path of the golden file, e.g. "testdata/partner_api.json", required.
+	Path string
+	Mode RecorderMode
+	// RedactHeaders request and response headers whose values are not written to the golden
+	// file, Authorization, Cookie and Set-Cookie are always redacted.
+	RedactHeaders []string
+	// Matchers decide which recorded interaction answers a request, default DefaultMatchers.
+	Matchers []Matcher
+	// Strict fails requests without a matching interaction in replay mode, otherwise they are
+	// sent to the network.
+	Strict bool
+}
+
+// Recorder records interactions of an HTTPClient to a golden file and replays them, so that
+// tests run offline and deterministically. Install it with HTTPClient.Use as the last middleware.
+type Recorder struct {
+	cfg    RecorderConfig
+	redact map[string]bool
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// NewRecorder creates a recorder, in replay mode the golden file must exist.
+func NewRecorder(cfg RecorderConfig) (*Recorder, error) {
+	if len(cfg.Matchers) == 0 {
+		cfg.Matchers = DefaultMatchers
+	}
+	r := &Recorder{cfg: cfg, redact: map[string]bool{}}
+	for _, h := range append([]string{"Authorization", "Cookie", "Set-Cookie"}, cfg.RedactHeaders...) {
+		r.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	if cfg.Mode == ModeReplay {
+		data, err := ioutil.ReadFile(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &r.interactions); err != nil {
+			return nil, fmt.Errorf("httplib: invalid golden file %s: %v", cfg.Path, err)
+		}
+		r.used = make([]bool, len(r.interactions))
+	}
+	return r, nil
+}
+
+// Interactions returns the recorded or loaded interactions.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]Interaction, len(r.interactions))
+	for i, it := range r.interactions {
+		list[i] = *it
+	}
+	return list
+}
+
+// Middleware returns the middleware recording or replaying the requests of a client.
+func (r *Recorder) Middleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req, body, err := readRequestBody(req)
+			if err != nil {
+				return nil, err
+			}
+			if r.cfg.Mode == ModeRecord {
+				return r.record(next, req, body)
+			}
+			if it := r.match(req, body); it != nil {
+				return it.Response.response(req)
+			}
+			if r.cfg.Strict {
+				return nil, fmt.Errorf("httplib: no recorded interaction matches %s %s in %s", req.Method, req.URL, r.cfg.Path)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// match returns the first unused matching interaction, or the last used one so that
+// repeated requests are answered as well.
+func (r *Recorder) match(req *http.Request, body []byte) *Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var reuse *Interaction
+	for i, it := range r.interactions {
+		ok := true
+		for _, m := range r.cfg.Matchers {
+			if !m(req, body, &it.Request) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return it
+		}
+		reuse = it
+	}
+	return reuse
+}
+
+func (r *Recorder) record(next http.RoundTripper, req *http.Request, body []byte) (*http.Response, error) {
+	rsp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rspBody, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(rspBody))
+
+	it := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Header: r.redacted(req.Header),
+		},
+		Response: RecordedResponse{
+			StatusCode: rsp.StatusCode,
+			Header:     r.redacted(rsp.Header),
+		},
+	}
+	it.Request.Body, it.Request.BodyBase64 = encodeRecordedBody(body)
+	it.Response.Body, it.Response.BodyBase64 = encodeRecordedBody(rspBody)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, it)
+	r.used = append(r.used, true)
+	return rsp, r.saveLocked()
+}
+
+// saveLocked rewrites the golden file with every interaction recorded so far.
+func (r *Recorder) saveLocked() error {
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(r.cfg.Path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.cfg.Path, append(data, '\n'), 0644)
+}
+
+func (r *Recorder) redacted(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	out := h.Clone()
+	for k, values := range out {
+		if r.redact[k] {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+func (rr *RecordedResponse) response(req *http.Request) (*http.Response, error) {
+	body, err := decodeRecordedBody(rr.Body, rr.BodyBase64)
+	if err != nil {
+		return nil, err
+	}
+	header := rr.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(rr.StatusCode) + " " + http.StatusText(rr.StatusCode),
+		StatusCode:    rr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody reads the body and returns a clone of req carrying it for the next round
+// tripper, req itself is not modified.
+func readRequestBody(req *http.Request) (*http.Request, []byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = ioutil.NopCloser(bytes.NewReader(body))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	clone.ContentLength = int64(len(body))
+	return clone, body, nil
+}
+
+func encodeRecordedBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeRecordedBody(body string, isBase64 bool) ([]byte, error) {
+	if !isBase64 {
+		return []byte(body), nil
+	}
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, errors.New("httplib: invalid base64 body in golden file")
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package httplib_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Api-Echo", r.Header.Get("X-Api-Key"))
+		w.Write([]byte(r.Method + " " + r.URL.RawQuery + " " + string(body)))
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "httplib-recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixtures", "partner.json")
+
+	send := func(client *httplib.HTTPClient, method string, params map[string]string, body interface{}) (string, error) {
+		result := bytes.NewBuffer(nil)
+		err := client.Do(context.Background(), method, &httplib.RequestArgs{
+			TraceID:     "fakeID",
+			URL:         srv.URL + "/v1/price",
+			Headers:     map[string]string{"X-Api-Key": "key-1", "Authorization": "Bearer token"},
+			Params:      params,
+			Body:        body,
+			BytesResult: result,
+		})
+		return result.String(), err
+	}
+
+	rec, err := httplib.NewRecorder(httplib.RecorderConfig{Path: path, Mode: httplib.ModeRecord, RedactHeaders: []string{"X-Api-Key", "X-Api-Echo"}})
+	require.NoError(t, err)
+	client := httplib.NewHTTPClient().Use(rec.Middleware())
+	got, err := send(client, http.MethodGet, map[string]string{"a": "1", "b": "2"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "GET a=1&b=2 ", got)
+	_, err = send(client, http.MethodPost, nil, map[string]int{"x": 1, "y": 2})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, hits)
+
+	golden, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(golden), "key-1")
+	require.NotContains(t, string(golden), "secret")
+	require.NotContains(t, string(golden), "Bearer token")
+	require.Contains(t, string(golden), httplib.Redacted)
+
+	// replay matches query regardless of order and json bodies semantically
+	rec, err = httplib.NewRecorder(httplib.RecorderConfig{Path: path, Strict: true})
+	require.NoError(t, err)
+	client = httplib.NewHTTPClient().Use(rec.Middleware())
+	got, err = send(client, http.MethodGet, map[string]string{"b": "2", "a": "1"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "GET a=1&b=2 ", got)
+	got, err = send(client, http.MethodPost, nil, []byte(`{"y":2,"x":1}`))
+	require.NoError(t, err)
+	require.Equal(t, `POST  {"x":1,"y":2}`, got)
+	// repeated requests are answered again
+	_, err = send(client, http.MethodGet, map[string]string{"a": "1", "b": "2"}, nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, hits)
+
+	_, err = send(client, http.MethodGet, map[string]string{"a": "3"}, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no recorded interaction")
+
+	// without strict mode misses go to the network
+	rec, err = httplib.NewRecorder(httplib.RecorderConfig{Path: path, Matchers: []httplib.Matcher{httplib.MatchMethod, httplib.MatchURL}})
+	require.NoError(t, err)
+	client = httplib.NewHTTPClient().Use(rec.Middleware())
+	got, err = send(client, http.MethodPut, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "PUT  ", got)
+	require.EqualValues(t, 3, hits)
+
+	_, err = httplib.NewRecorder(httplib.RecorderConfig{Path: filepath.Join(dir, "missing.json")})
+	require.Error(t, err)
+}
+
+func TestRecorder_RequestUnmodified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httplib-recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	rec, err := httplib.NewRecorder(httplib.RecorderConfig{Path: filepath.Join(dir, "golden.json"), Mode: httplib.ModeRecord})
+	require.NoError(t, err)
+
+	var sent []byte
+	next := httplib.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent, _ = ioutil.ReadAll(req.Body)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil)), Request: req}, nil
+	})
+	body := ioutil.NopCloser(bytes.NewReader([]byte("payload")))
+	req, err := http.NewRequest(http.MethodPost, "http://127.0.0.1/", body)
+	require.NoError(t, err)
+	_, err = rec.Middleware()(next).RoundTrip(req)
+	require.NoError(t, err)
+	// the buffered body is sent on a clone, the request of the caller keeps its body
+	require.Equal(t, "payload", string(sent))
+	require.True(t, req.Body == body)
+}
@@ -0,0 +1,181 @@
+package httplib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DeBankDeFi/golib/syserror"
+)
+
+// RetryPolicy retries failed requests with exponential backoff and jitter.
+//
+// Only idempotent methods (GET, HEAD, OPTIONS, PUT, DELETE, TRACE) are retried, unless
+// RetryNonIdempotent is set or the request is marked with RequestArgs.Idempotent.
+type RetryPolicy struct {
+	// MaxAttempts total attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff wait before the second attempt.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential backoff.
+	MaxBackoff time.Duration
+	// Multiplier growth factor of the backoff between attempts.
+	Multiplier float64
+	// Jitter randomly reduces each backoff by up to this fraction, from 0 to 1.
+	Jitter float64
+	// MaxRetryAfter the longest Retry-After of a 429 or 503 response which is honored,
+	// a response asking for a longer wait is returned to the caller.
+	MaxRetryAfter time.Duration
+	// RetryOn reports whether an attempt should be retried, defaults to DefaultRetryOn.
+	RetryOn func(rsp *http.Response, err error) bool
+	// RetryNonIdempotent retries non-idempotent methods such as POST and PATCH as well.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns a policy of 3 attempts with backoff from 100ms up to 5s.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxRetryAfter:  30 * time.Second,
+	}
+}
+
+// DefaultRetryOn retries network errors other than cancellation and deadline of the request
+// context, and responses with status 429, 502, 503 or 504.
+func DefaultRetryOn(rsp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// SetRetryPolicy 设置重试策略, nil表示不重试
+func (c *HTTPClient) SetRetryPolicy(p *RetryPolicy) *HTTPClient {
+	c.retryPolicy = p
+	return c
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// attempts returns how many attempts a request may make.
+func (p *RetryPolicy) attempts(method string, args *RequestArgs) int {
+	if p == nil || p.MaxAttempts <= 1 {
+		return 1
+	}
+	if !isIdempotent(method) && !args.Idempotent && !p.RetryNonIdempotent {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// retry reports whether the attempt should be retried and how long to wait before it.
+func (p *RetryPolicy) retry(attempt int, rsp *http.Response, err error) (time.Duration, bool) {
+	retryOn := p.RetryOn
+	if retryOn == nil {
+		retryOn = DefaultRetryOn
+	}
+	if !retryOn(rsp, err) {
+		return 0, false
+	}
+	if err == nil && (rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := parseRetryAfter(rsp.Header.Get("Retry-After")); ok {
+			if p.MaxRetryAfter > 0 && wait > p.MaxRetryAfter {
+				return 0, false
+			}
+			return wait, true
+		}
+	}
+	return p.backoff(attempt), true
+}
+
+// backoff returns the wait after the given attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// parseRetryAfter parses delay-seconds or an http date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			seconds = 0
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// discardBody drains a bit of the body so that the connection can be reused, then closes it.
+func discardBody(rsp *http.Response) {
+	if rsp == nil || rsp.Body == nil {
+		return
+	}
+	io.CopyN(ioutil.Discard, rsp.Body, 4<<10)
+	rsp.Body.Close()
+}
+
+// withAttempts records the number of attempts in the fields of a syserror.
+func withAttempts(err error, attempts int) error {
+	if e, ok := err.(*syserror.SysError); ok {
+		if e.MemoryValues == nil {
+			e.MemoryValues = make(map[string]interface{})
+		}
+		e.MemoryValues["Attempts"] = attempts
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+package httplib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newFlakyServer(failures int32, status int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	return srv, &calls
+}
+
+func TestRetryPolicy(t *testing.T) {
+	srv, calls := newFlakyServer(2, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := httplib.NewHTTPClient().SetRetryPolicy(policy)
+
+	var result struct {
+		OK bool `json:"ok"`
+	}
+	err := client.Get(context.Background(), &httplib.RequestArgs{
+		TraceID:    "fakeID",
+		URL:        srv.URL,
+		JSONResult: &result,
+	})
+	require.NoError(t, err)
+	require.True(t, result.OK)
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestRetryPolicy_Exhausted(t *testing.T) {
+	srv, calls := newFlakyServer(10, http.StatusBadGateway)
+	defer srv.Close()
+
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	err := httplib.NewHTTPClient().SetRetryPolicy(policy).Get(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+	})
+	require.Error(t, err)
+	sysErr := err.(*syserror.SysError)
+	require.Equal(t, "HTTP_STATUS_CODE_NOT_OK", sysErr.ID)
+	require.Equal(t, 3, sysErr.MemoryValues["Attempts"])
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestRetryPolicy_NonIdempotent(t *testing.T) {
+	srv, calls := newFlakyServer(1, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := httplib.NewHTTPClient().SetRetryPolicy(policy)
+
+	err := client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Body:    map[string]string{"foo": "bar"},
+	})
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+	err = client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID:    "fakeID",
+		URL:        srv.URL,
+		Body:       map[string]string{"foo": "bar"},
+		Idempotent: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
+
+func TestRetryPolicy_RetryAfterTooLong(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	err := httplib.NewHTTPClient().SetRetryPolicy(httplib.DefaultRetryPolicy()).Get(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+	})
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
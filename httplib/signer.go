@@ -0,0 +1,318 @@
+package httplib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrIDSignRequest syserror ID of requests a Signer failed to sign.
+const ErrIDSignRequest = "HTTP_SIGN_REQUEST"
+
+// Signer authenticates a request. Signers run on every attempt, after headers, params and
+// ReqHandle were applied and right before the request is sent, so that timestamps are fresh
+// and retries sent to another endpoint are signed for it.
+//
+// body is the finalized request body, nil if the request has none or streams a multipart body.
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignerFunc adapts a function to a Signer.
+type SignerFunc func(req *http.Request, body []byte) error
+
+// Sign calls f.
+func (f SignerFunc) Sign(req *http.Request, body []byte) error {
+	return f(req, body)
+}
+
+// BearerToken sets the Authorization header to a bearer token.
+func BearerToken(token string) Signer {
+	return HeaderSigner("Authorization", "Bearer "+token)
+}
+
+// HeaderSigner sets a header, e.g. HeaderSigner("X-Api-Key", key).
+func HeaderSigner(header, value string) Signer {
+	return &headerSigner{header: header, value: value}
+}
+
+type headerSigner struct {
+	header string
+	value  string
+}
+
+func (s *headerSigner) Sign(req *http.Request, body []byte) error {
+	req.Header.Set(s.header, s.value)
+	return nil
+}
+
+// CacheIdentity the header is static, its value identifies the credentials.
+func (s *headerSigner) CacheIdentity() string {
+	return "header " + sha256Hex([]byte(http.CanonicalHeaderKey(s.header)+":"+s.value))
+}
+
+// SetSigner 设置请求签名, 按顺序执行, 每次尝试发送前都重新签名
+// 启用响应缓存时, 实现CacheIdentifier的签名按其身份而不是Authorization头缓存
+func (c *HTTPClient) SetSigner(signers ...Signer) *HTTPClient {
+	c.signers = signers
+	return c
+}
+
+// sign runs the signers of the client and of the request.
+func (c *HTTPClient) sign(req *http.Request, body *requestBody, args *RequestArgs) error {
+	if len(c.signers) == 0 && args.Signer == nil {
+		return nil
+	}
+	var data []byte
+	if body != nil && body.multipart == nil {
+		data = body.data
+	}
+	for _, s := range c.signers {
+		if s == nil {
+			continue
+		}
+		if err := s.Sign(req, data); err != nil {
+			return err
+		}
+	}
+	if args.Signer != nil {
+		return args.Signer.Sign(req, data)
+	}
+	return nil
+}
+
+// Canonicalizer builds the string an HMACSigner signs.
+type Canonicalizer func(req *http.Request, body []byte, timestamp string) string
+
+// CanonicalLines joins method, path with query, timestamp and body with newlines.
+func CanonicalLines(req *http.Request, body []byte, timestamp string) string {
+	return strings.Join([]string{req.Method, req.URL.RequestURI(), timestamp, string(body)}, "\n")
+}
+
+// CanonicalConcat concatenates timestamp, method, path with query and body, as used by
+// OKX and Coinbase.
+func CanonicalConcat(req *http.Request, body []byte, timestamp string) string {
+	return timestamp + req.Method + req.URL.RequestURI() + string(body)
+}
+
+// HMACSigner signs requests with an HMAC of a canonical string, the scheme of most exchange
+// and custody APIs.
+type HMACSigner struct {
+	// Secret HMAC key, required.
+	Secret []byte
+
+	// KeyID sent in KeyIDHeader, optional.
+	KeyID       string
+	KeyIDHeader string
+
+	// SignatureHeader default X-Signature.
+	SignatureHeader string
+	// TimestampHeader default X-Timestamp.
+	TimestampHeader string
+
+	// Canonicalize default CanonicalLines.
+	Canonicalize Canonicalizer
+	// Hash default sha256.New.
+	Hash func() hash.Hash
+	// Encode encodes the signature, default hex.EncodeToString, e.g. base64.StdEncoding.EncodeToString.
+	Encode func([]byte) string
+	// Timestamp formats the signing time, default unix seconds.
+	Timestamp func(time.Time) string
+	// Now default time.Now.
+	Now func() time.Time
+}
+
+// CacheIdentity identifies the key id and secret, see CacheIdentifier.
+func (s *HMACSigner) CacheIdentity() string {
+	return "hmac " + sha256Hex([]byte(s.KeyID+"\n"+sha256Hex(s.Secret)))
+}
+
+// Sign sets the timestamp, key id and signature headers.
+func (s *HMACSigner) Sign(req *http.Request, body []byte) error {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	if s.Timestamp != nil {
+		timestamp = s.Timestamp(now)
+	}
+	canonicalize := s.Canonicalize
+	if canonicalize == nil {
+		canonicalize = CanonicalLines
+	}
+	newHash := s.Hash
+	if newHash == nil {
+		newHash = sha256.New
+	}
+	encode := s.Encode
+	if encode == nil {
+		encode = hex.EncodeToString
+	}
+
+	mac := hmac.New(newHash, s.Secret)
+	mac.Write([]byte(canonicalize(req, body, timestamp)))
+
+	req.Header.Set(headerOr(s.TimestampHeader, "X-Timestamp"), timestamp)
+	if s.KeyID != "" && s.KeyIDHeader != "" {
+		req.Header.Set(s.KeyIDHeader, s.KeyID)
+	}
+	req.Header.Set(headerOr(s.SignatureHeader, "X-Signature"), encode(mac.Sum(nil)))
+	return nil
+}
+
+func headerOr(header, def string) string {
+	if header == "" {
+		return def
+	}
+	return header
+}
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+)
+
+// SigV4Signer signs requests with AWS Signature Version 4, for AWS services and compatible
+// APIs such as MinIO or Cloudflare R2.
+type SigV4Signer struct {
+	AccessKey string
+	SecretKey string
+	// SessionToken of temporary credentials, optional.
+	SessionToken string
+
+	Region  string
+	Service string
+
+	// ContentSHA256Header sends the payload hash in X-Amz-Content-Sha256, required by S3.
+	ContentSHA256Header bool
+
+	// Now default time.Now.
+	Now func() time.Time
+}
+
+// CacheIdentity identifies the access key, region and service, see CacheIdentifier.
+func (s *SigV4Signer) CacheIdentity() string {
+	return "sigv4 " + sha256Hex([]byte(s.AccessKey+"\n"+s.Region+"\n"+s.Service))
+}
+
+// Sign sets the X-Amz-Date and Authorization headers. Host, Content-Type and X-Amz-*
+// headers are signed.
+func (s *SigV4Signer) Sign(req *http.Request, body []byte) error {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().UTC()
+	date := t.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", date)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	payloadHash := sha256Hex(body)
+	if body == nil && req.Body != nil && req.Body != http.NoBody {
+		// streamed bodies cannot be hashed before they are sent
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	if s.ContentSHA256Header {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, values := range req.Header {
+		name := strings.ToLower(k)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			headers[name] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		sigV4Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format("20060102"), s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, date, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return nil
+}
+
+// sigV4Query encodes the query sorted by key and value, with spaces as %20.
+func sigV4Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	escaped := make(map[string]string, len(query))
+	for k := range query {
+		e := sigV4Escape(k)
+		keys = append(keys, e)
+		escaped[e] = k
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(query))
+	for _, k := range keys {
+		values := make([]string, len(query[escaped[k]]))
+		for i, v := range query[escaped[k]] {
+			values[i] = sigV4Escape(v)
+		}
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,173 @@
+package httplib_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMACSigner_Retry(t *testing.T) {
+	secret := []byte("secret")
+	var mu sync.Mutex
+	var timestamps []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ts := r.Header.Get("OK-ACCESS-TIMESTAMP")
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(ts + r.Method + r.URL.RequestURI() + string(body)))
+		if r.Header.Get("OK-ACCESS-SIGN") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) ||
+			r.Header.Get("OK-ACCESS-KEY") != "key-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		timestamps = append(timestamps, ts)
+		if len(timestamps) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Unix(1700000000, 0)
+	signer := &httplib.HMACSigner{
+		Secret:          secret,
+		KeyID:           "key-1",
+		KeyIDHeader:     "OK-ACCESS-KEY",
+		SignatureHeader: "OK-ACCESS-SIGN",
+		TimestampHeader: "OK-ACCESS-TIMESTAMP",
+		Canonicalize:    httplib.CanonicalConcat,
+		Encode:          base64.StdEncoding.EncodeToString,
+		Now: func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		},
+	}
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := httplib.NewHTTPClient().SetRetryPolicy(policy).SetSigner(signer)
+	err := client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID:    "fakeID",
+		URL:        srv.URL + "/api/v5/trade/order",
+		Params:     map[string]string{"instId": "ETH-USDT"},
+		Body:       map[string]string{"side": "buy"},
+		Idempotent: true,
+	})
+	require.NoError(t, err)
+	// every attempt is signed again with a fresh timestamp
+	require.Equal(t, []string{"1700000001", "1700000002"}, timestamps)
+
+	signer.Secret = []byte("wrong")
+	err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL})
+	httpErr, ok := httplib.AsHTTPError(err)
+	require.True(t, ok)
+	require.Equal(t, http.StatusUnauthorized, httpErr.StatusCode)
+}
+
+func TestSigV4Signer(t *testing.T) {
+	// get-vanilla of the AWS Signature Version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	signer := &httplib.SigV4Signer{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+		Service:   "service",
+		Now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+	require.NoError(t, signer.Sign(req, nil))
+	require.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestSigner_Headers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization") + " " + r.Header.Get("X-Api-Key")))
+	}))
+	defer srv.Close()
+
+	client := httplib.NewHTTPClient().SetSigner(httplib.BearerToken("token"))
+	result := bytes.NewBuffer(nil)
+	err := client.Get(context.Background(), &httplib.RequestArgs{
+		TraceID: "fakeID",
+		URL:     srv.URL,
+		Signer:  httplib.HeaderSigner("X-Api-Key", "key"),
+		// signers run after ReqHandle
+		ReqHandle: func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer other")
+		},
+		BytesResult: result,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Bearer token key", result.String())
+
+	failing := httplib.SignerFunc(func(req *http.Request, body []byte) error {
+		return errors.New("key expired")
+	})
+	err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, Signer: failing})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDSignRequest, err.(*syserror.SysError).ID)
+}
+
+func TestSigner_Cache(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.Header.Get("X-Amz-Date")))
+	}))
+	defer srv.Close()
+	now := time.Unix(1700000000, 0)
+	newSigner := func(accessKey string) *httplib.SigV4Signer {
+		return &httplib.SigV4Signer{
+			AccessKey: accessKey,
+			SecretKey: "secret",
+			Region:    "us-east-1",
+			Service:   "execute-api",
+			Now: func() time.Time {
+				now = now.Add(time.Second)
+				return now
+			},
+		}
+	}
+	store := httplib.NewLRUCacheStore(0)
+	get := func(signer httplib.Signer) {
+		client := httplib.NewHTTPClient().SetCache(httplib.CacheConfig{Store: store}).SetSigner(signer)
+		require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}))
+	}
+
+	// every request is signed with a new date, the cache keys them by the access key
+	signer := newSigner("AKID1")
+	for i := 0; i < 3; i++ {
+		get(signer)
+	}
+	require.EqualValues(t, 1, atomic.LoadInt32(&hits))
+	get(newSigner("AKID2"))
+	require.EqualValues(t, 2, atomic.LoadInt32(&hits))
+
+	// a signer without an identity keys by its Authorization header
+	get(httplib.SignerFunc(func(req *http.Request, body []byte) error {
+		req.Header.Set("Authorization", "Custom "+time.Now().String())
+		return nil
+	}))
+	require.EqualValues(t, 3, atomic.LoadInt32(&hits))
+}
@@ -0,0 +1,262 @@
+package httplib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DeBankDeFi/golib/syserror"
+)
+
+// ErrIDBodyTooLarge the buffered response body exceeded the limit of SetMaxBodySize.
+const ErrIDBodyTooLarge = "HTTP_RSP_BODY_TOO_LARGE"
+
+// maxErrorBodySize how much of the body of an unexpected status is kept in the error.
+const maxErrorBodySize = 64 << 10
+
+// SetMaxBodySize 设置缓存读取的响应Body上限, 超过时返回 ErrIDBodyTooLarge, 0表示不限制.
+// 对 Stream, Download 和 RequestArgs.BodyWriter 不生效
+func (c *HTTPClient) SetMaxBodySize(n int64) *HTTPClient {
+	c.maxBodySize = n
+	return c
+}
+
+func bodyTooLarge(args *RequestArgs, size, limit int64) error {
+	return syserror.New(args.TraceID, ErrIDBodyTooLarge, "response body exceeds the limit", map[string]interface{}{
+		"BodySize":    size,
+		"MaxBodySize": limit,
+		"URL":         args.URL,
+	})
+}
+
+// Stream sends the request and returns the response body after the status code was validated,
+// the caller must close it. Response headers are copied to args.ResponseHeaders, JSONResult,
+// BytesResult and BodyWriter are ignored.
+//
+// The timeout of the client does not limit the whole stream, it bounds the wait for the
+// response headers and for each read of the body. Use ctx to limit the total duration.
+func (c *HTTPClient) Stream(ctx context.Context, method string, args *RequestArgs) (io.ReadCloser, error) {
+	req, rsp, attempts, err := c.send(ctx, method, args, true)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.checkStatus(req, rsp, args); err != nil {
+		rsp.Body.Close()
+		return nil, withAttempts(err, attempts)
+	}
+	c.copyHeaders(rsp, args)
+	return rsp.Body, nil
+}
+
+// Download GETs args.URL into the file at path. An existing file is resumed with a Range
+// request, and a download interrupted by a network error is resumed as often as the retry
+// policy allows. A server ignoring the range restarts the file from the beginning.
+// Like Stream, the timeout of the client applies to the headers and each read, not the download.
+func (c *HTTPClient) Download(ctx context.Context, args *RequestArgs, path string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return syserror.New(args.TraceID, "OPEN_DOWNLOAD_FILE", err.Error(), map[string]interface{}{
+			"Path": path,
+		})
+	}
+	defer f.Close()
+
+	maxAttempts := c.retryPolicy.attempts(http.MethodGet, args)
+	for attempt := 1; ; attempt++ {
+		offset, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			return syserror.New(args.TraceID, "SEEK_DOWNLOAD_FILE", err.Error(), map[string]interface{}{
+				"Path": path,
+			})
+		}
+		a := *args
+		a.Headers = make(map[string]string, len(args.Headers)+1)
+		for k, v := range args.Headers {
+			a.Headers[k] = v
+		}
+		a.ExpectedStatusCode = []int{http.StatusOK}
+		if offset > 0 {
+			a.Headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+			a.ExpectedStatusCode = append(a.ExpectedStatusCode, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable)
+		}
+
+		req, rsp, _, err := c.send(ctx, http.MethodGet, &a, true)
+		if err != nil {
+			return err
+		}
+		if err = c.checkStatus(req, rsp, &a); err != nil {
+			rsp.Body.Close()
+			return err
+		}
+		c.copyHeaders(rsp, args)
+		switch rsp.StatusCode {
+		case http.StatusRequestedRangeNotSatisfiable:
+			// the file is complete if the server reports its size as the offset
+			rsp.Body.Close()
+			if _, size, ok := parseContentRange(rsp.Header.Get("Content-Range")); ok && size == offset {
+				return nil
+			}
+			return syserror.New(args.TraceID, "HTTP_DOWNLOAD_RANGE", "range not satisfiable", map[string]interface{}{
+				"Offset":       offset,
+				"ContentRange": rsp.Header.Get("Content-Range"),
+				"Path":         path,
+			})
+		case http.StatusPartialContent:
+			if start, _, ok := parseContentRange(rsp.Header.Get("Content-Range")); !ok || start != offset {
+				rsp.Body.Close()
+				return syserror.New(args.TraceID, "HTTP_DOWNLOAD_RANGE", "unexpected content range", map[string]interface{}{
+					"Offset":       offset,
+					"ContentRange": rsp.Header.Get("Content-Range"),
+					"Path":         path,
+				})
+			}
+		case http.StatusOK:
+			if offset > 0 {
+				if err = f.Truncate(0); err == nil {
+					_, err = f.Seek(0, io.SeekStart)
+				}
+				if err != nil {
+					rsp.Body.Close()
+					return syserror.New(args.TraceID, "TRUNCATE_DOWNLOAD_FILE", err.Error(), map[string]interface{}{
+						"Path": path,
+					})
+				}
+			}
+		}
+
+		_, err = io.Copy(f, rsp.Body)
+		rsp.Body.Close()
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*os.PathError); ok {
+			return syserror.New(args.TraceID, "WRITE_DOWNLOAD_FILE", err.Error(), map[string]interface{}{
+				"Path": path,
+			})
+		}
+		if attempt < maxAttempts && ctx.Err() == nil {
+			if err = sleepContext(ctx, c.retryPolicy.backoff(attempt)); err == nil {
+				continue
+			}
+		}
+		return withAttempts(requestError(req, args, "HTTP_READ_RSP_BODY", err, map[string]interface{}{
+			"Path": path,
+		}), attempt)
+	}
+}
+
+// errIdleTimeout a streamed response stalled for longer than the timeout of the client, it is a
+// timeout net.Error so that downloads are resumed.
+type errIdleTimeout struct{}
+
+func (errIdleTimeout) Error() string   { return "httplib: stream idle timeout exceeded" }
+func (errIdleTimeout) Timeout() bool   { return true }
+func (errIdleTimeout) Temporary() bool { return true }
+
+// idleTimeoutTransport cancels a request if its headers or a read of its body take longer
+// than timeout.
+type idleTimeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	b := &idleTimeoutBody{cancel: cancel, timeout: t.timeout}
+	b.timer = time.AfterFunc(t.timeout, b.expire)
+	rsp, err := t.next.RoundTrip(req.WithContext(ctx))
+	b.timer.Stop()
+	if err != nil {
+		cancel()
+		if b.expired() {
+			return nil, errIdleTimeout{}
+		}
+		return nil, err
+	}
+	b.ReadCloser = rsp.Body
+	rsp.Body = b
+	return rsp, nil
+}
+
+type idleTimeoutBody struct {
+	io.ReadCloser
+	cancel  context.CancelFunc
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+func (b *idleTimeoutBody) expire() {
+	atomic.StoreInt32(&b.fired, 1)
+	b.cancel()
+}
+
+func (b *idleTimeoutBody) expired() bool {
+	return atomic.LoadInt32(&b.fired) == 1
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	// only the wait for data counts, not the time the caller spends between reads
+	if b.expired() {
+		return 0, errIdleTimeout{}
+	}
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && err != io.EOF && b.expired() {
+		err = errIdleTimeout{}
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// parseContentRange parses "bytes first-last/size" and "bytes */size", first is -1 for the
+// latter. An unknown size "*" is returned as -1.
+func parseContentRange(v string) (first, size int64, ok bool) {
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, false
+	}
+	v = strings.TrimPrefix(v, "bytes ")
+	i := strings.IndexByte(v, '/')
+	if i < 0 {
+		return 0, 0, false
+	}
+	rng, total := v[:i], v[i+1:]
+	size = -1
+	if total != "*" {
+		var err error
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if rng == "*" {
+		return -1, size, true
+	}
+	j := strings.IndexByte(rng, '-')
+	if j < 0 {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(rng[:j], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return first, size, true
+}
@@ -0,0 +1,145 @@
+package httplib_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/syserror"
+
+	"github.com/stretchr/testify/require"
+)
+
+var archive = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+
+func TestHttpStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Size", strconv.Itoa(len(archive)))
+		w.Write(archive)
+	}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetMaxBodySize(1024)
+
+	headers := map[string][]string{}
+	body, err := client.Stream(context.Background(), http.MethodGet, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, ResponseHeaders: headers})
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, archive, data)
+	require.Equal(t, []string{strconv.Itoa(len(archive))}, headers["X-Size"])
+
+	_, err = client.Stream(context.Background(), http.MethodGet, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/missing"})
+	require.Error(t, err)
+	require.Equal(t, "HTTP_STATUS_CODE_NOT_OK", err.(*syserror.SysError).ID)
+
+	// the limit guards the buffered path only
+	var w bytes.Buffer
+	require.NoError(t, client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, BodyWriter: &w}))
+	require.Equal(t, archive, w.Bytes())
+
+	err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL, BytesResult: bytes.NewBuffer(nil)})
+	require.Error(t, err)
+	require.Equal(t, httplib.ErrIDBodyTooLarge, err.(*syserror.SysError).ID)
+}
+
+func TestHttpStream_Timeout(t *testing.T) {
+	// the body takes longer than the client timeout, but data keeps flowing
+	var stall int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/headers" {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		for i := 0; i < 8; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			if i == 4 && atomic.LoadInt32(&stall) == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			time.Sleep(25 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+	client := httplib.NewHTTPClient().SetTimeout(100 * time.Millisecond)
+
+	body, err := client.Stream(context.Background(), http.MethodGet, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL})
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, bytes.Repeat([]byte("chunk"), 8), data)
+
+	dir, err := ioutil.TempDir("", "httplib")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "slow")
+	require.NoError(t, client.Download(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}, path))
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte("chunk"), 8), data)
+
+	// a stalled body and late headers still time out
+	atomic.StoreInt32(&stall, 1)
+	body, err = client.Stream(context.Background(), http.MethodGet, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL})
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(body)
+	require.Error(t, err)
+	body.Close()
+	_, err = client.Stream(context.Background(), http.MethodGet, &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL + "/headers"})
+	require.Error(t, err)
+}
+
+func TestHttpDownload(t *testing.T) {
+	var requests, interrupted int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Range") == "" && atomic.CompareAndSwapInt32(&interrupted, 0, 1) {
+			// drop the connection half way through the first download
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+			w.Write(archive[:len(archive)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "archive", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "httplib")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive")
+
+	policy := httplib.DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := httplib.NewHTTPClient().SetRetryPolicy(policy)
+	require.NoError(t, client.Download(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}, path))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, archive, data)
+	require.EqualValues(t, 2, requests)
+
+	// a complete file is answered with 416
+	require.NoError(t, client.Download(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}, path))
+	require.EqualValues(t, 3, requests)
+
+	// a partial file is resumed
+	require.NoError(t, ioutil.WriteFile(path, archive[:100], 0644))
+	require.NoError(t, client.Download(context.Background(), &httplib.RequestArgs{TraceID: "fakeID", URL: srv.URL}, path))
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, archive, data)
+}
@@ -0,0 +1,253 @@
+package httplib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSFiles certificate files of an mTLS client, reloaded when they change on disk, e.g. when
+// cert-manager or vault rotate a mounted secret.
+type TLSFiles struct {
+	// CertFile and KeyFile PEM client certificate chain and key, optional.
+	CertFile string
+	KeyFile  string
+	// CAFile PEM bundle of the CAs server certificates are verified against, optional, the
+	// system roots are used if empty.
+	CAFile string
+	// ServerName overrides the name the server certificate is verified for, required with a
+	// CAFile if servers are addressed by ip.
+	ServerName string
+	// ReloadInterval how often the files are checked for changes, default 1 minute.
+	ReloadInterval time.Duration
+}
+
+// TLSStats state of the certificates of a TLSReloader, e.g. for expiry alerts.
+type TLSStats struct {
+	// CertNotAfter expiry of the client certificate, zero without one.
+	CertNotAfter time.Time
+	// CANotAfter earliest expiry of the CA bundle, zero without one.
+	CANotAfter time.Time
+	// ExpiresIn time until the first of the certificates expires, negative once expired.
+	ExpiresIn time.Duration
+
+	LastReload time.Time
+	Reloads    uint64
+	// Errors failed reloads, the previous certificates stay in use.
+	Errors    uint64
+	LastError string
+}
+
+// TLSReloader serves the certificates of TLSFiles to an HTTPS client and reloads them without
+// a restart:
+//
+//	r, err := httplib.NewTLSReloader(httplib.TLSFiles{CertFile: "tls.crt", KeyFile: "tls.key", CAFile: "ca.crt"})
+//	client := httplib.NewHTTPSClient(r.TLSConfig())
+//
+// New connections use the reloaded certificates, established ones are kept.
+type TLSReloader struct {
+	files TLSFiles
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	roots   *x509.CertPool
+	stats   TLSStats
+	modTime map[string]time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewTLSReloader loads the files, failing if they are invalid, and starts watching them.
+func NewTLSReloader(files TLSFiles) (*TLSReloader, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("httplib: tls cert file and key file must be set together")
+	}
+	if files.ReloadInterval <= 0 {
+		files.ReloadInterval = time.Minute
+	}
+	r := &TLSReloader{files: files, stop: make(chan struct{})}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	r.wg.Add(1)
+	go r.watch()
+	return r, nil
+}
+
+// TLSConfig returns a config which always presents the current client certificate and
+// verifies servers against the current CA bundle.
+func (r *TLSReloader) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		ServerName: r.files.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if r.files.CertFile != "" {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		}
+	}
+	if r.files.CAFile != "" {
+		// RootCAs cannot be swapped on a config in use, verify with the current bundle instead
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = r.verify
+	}
+	return cfg
+}
+
+func (r *TLSReloader) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("httplib: server presented no certificate")
+	}
+	// no server name is sent to ip addresses, do not skip the hostname check for them
+	name := cs.ServerName
+	if name == "" {
+		name = r.files.ServerName
+	}
+	if name == "" {
+		return errors.New("httplib: unknown server name, set TLSFiles.ServerName to connect to an ip address")
+	}
+	r.mu.RLock()
+	roots := r.roots
+	r.mu.RUnlock()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Reload loads the files now, on failure the previous certificates stay in use.
+func (r *TLSReloader) Reload() error {
+	modTime := make(map[string]time.Time)
+	for _, name := range []string{r.files.CertFile, r.files.KeyFile, r.files.CAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return r.failed(err)
+		}
+		modTime[name] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	var certNotAfter, caNotAfter time.Time
+	if r.files.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+		if err != nil {
+			return r.failed(err)
+		}
+		if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return r.failed(err)
+		}
+		cert, certNotAfter = &pair, pair.Leaf.NotAfter
+	}
+	var roots *x509.CertPool
+	if r.files.CAFile != "" {
+		data, err := ioutil.ReadFile(r.files.CAFile)
+		if err != nil {
+			return r.failed(err)
+		}
+		roots = x509.NewCertPool()
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			ca, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return r.failed(err)
+			}
+			roots.AddCert(ca)
+			if caNotAfter.IsZero() || ca.NotAfter.Before(caNotAfter) {
+				caNotAfter = ca.NotAfter
+			}
+		}
+		if caNotAfter.IsZero() {
+			return r.failed(errors.New("httplib: no certificate in ca file " + r.files.CAFile))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert, r.roots, r.modTime = cert, roots, modTime
+	r.stats.CertNotAfter, r.stats.CANotAfter = certNotAfter, caNotAfter
+	r.stats.LastReload = time.Now()
+	r.stats.Reloads++
+	r.stats.LastError = ""
+	return nil
+}
+
+func (r *TLSReloader) failed(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Errors++
+	r.stats.LastError = err.Error()
+	return err
+}
+
+// Stats returns a snapshot of the certificates and reloads.
+func (r *TLSReloader) Stats() TLSStats {
+	r.mu.RLock()
+	stats := r.stats
+	r.mu.RUnlock()
+	first := stats.CertNotAfter
+	if first.IsZero() || (!stats.CANotAfter.IsZero() && stats.CANotAfter.Before(first)) {
+		first = stats.CANotAfter
+	}
+	if !first.IsZero() {
+		stats.ExpiresIn = time.Until(first)
+	}
+	return stats
+}
+
+// Close stops watching the files, the loaded certificates remain usable.
+func (r *TLSReloader) Close() {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	r.wg.Wait()
+}
+
+func (r *TLSReloader) watch() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.files.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		if r.changed() {
+			r.Reload()
+		}
+	}
+}
+
+// changed reports whether a file was modified since the last successful load.
+func (r *TLSReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, modTime := range r.modTime {
+		info, err := os.Stat(name)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
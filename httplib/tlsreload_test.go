@@ -0,0 +1,190 @@
+package httplib_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DeBankDeFi/golib/httplib"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&serial, 1)),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key for name, valid for lifetime.
+func (ca *testCA) issue(t *testing.T, name string, lifetime time.Duration) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&serial, 1)),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	// write and rename so that the reloader never reads a partial file
+	require.NoError(t, ioutil.WriteFile(path+".tmp", data, 0600))
+	require.NoError(t, os.Rename(path+".tmp", path))
+}
+
+func TestTLSReloader(t *testing.T) {
+	caA, caB := newTestCA(t, "ca-a"), newTestCA(t, "ca-b")
+	var serverCert atomic.Value
+	setServerCert := func(ca *testCA) {
+		certPEM, keyPEM := ca.issue(t, "localhost", time.Hour)
+		pair, err := tls.X509KeyPair(certPEM, keyPEM)
+		require.NoError(t, err)
+		serverCert.Store(&pair)
+	}
+	setServerCert(caA)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caA.cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+			return
+		}
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return serverCert.Load().(*tls.Certificate), nil
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	dir, err := ioutil.TempDir("", "httplib-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := httplib.TLSFiles{
+		CertFile:       filepath.Join(dir, "tls.crt"),
+		KeyFile:        filepath.Join(dir, "tls.key"),
+		CAFile:         filepath.Join(dir, "ca.crt"),
+		ReloadInterval: 10 * time.Millisecond,
+	}
+	certPEM, keyPEM := caA.issue(t, "client-1", 2*time.Hour)
+	writeFile(t, files.CertFile, certPEM)
+	writeFile(t, files.KeyFile, keyPEM)
+	writeFile(t, files.CAFile, caA.pem)
+
+	r, err := httplib.NewTLSReloader(files)
+	require.NoError(t, err)
+	defer r.Close()
+	client := httplib.NewHTTPSClient(r.TLSConfig())
+	get := func() (string, error) {
+		// new connections pick up the reloaded certificates
+		client.Close()
+		rsp, err := client.NewRequest(http.MethodGet, url).TraceID("fakeID").Do(context.Background())
+		if err != nil {
+			return "", err
+		}
+		return string(rsp.Value), nil
+	}
+	name, err := get()
+	require.NoError(t, err)
+	require.Equal(t, "client-1", name)
+	stats := r.Stats()
+	require.EqualValues(t, 1, stats.Reloads)
+	require.True(t, stats.ExpiresIn > time.Hour && stats.ExpiresIn <= 2*time.Hour, stats.ExpiresIn.String())
+
+	// rotated client certificate
+	certPEM, keyPEM = caA.issue(t, "client-2", 3*time.Hour)
+	writeFile(t, files.KeyFile, keyPEM)
+	writeFile(t, files.CertFile, certPEM)
+	require.Eventually(t, func() bool {
+		name, err := get()
+		return err == nil && name == "client-2"
+	}, 2*time.Second, 10*time.Millisecond)
+	require.True(t, r.Stats().CertNotAfter.After(stats.CertNotAfter))
+
+	// the server moves to another CA, trusted once the bundle is updated
+	setServerCert(caB)
+	_, err = get()
+	require.Error(t, err)
+	writeFile(t, files.CAFile, append(append([]byte{}, caA.pem...), caB.pem...))
+	require.Eventually(t, func() bool {
+		_, err := get()
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// an invalid file keeps the previous certificates
+	writeFile(t, files.CertFile, []byte("garbage"))
+	require.Eventually(t, func() bool { return r.Stats().Errors > 0 }, 2*time.Second, 10*time.Millisecond)
+	require.NotEmpty(t, r.Stats().LastError)
+	name, err = get()
+	require.NoError(t, err)
+	require.Equal(t, "client-2", name)
+
+	// the https client supports protobuf like NewHTTPClient
+	var result wrappers.StringValue
+	err = client.Post(context.Background(), &httplib.RequestArgs{
+		TraceID:      "fakeID",
+		URL:          url,
+		Body:         &wrappers.StringValue{Value: "eth"},
+		ProtobufType: true,
+		JSONResult:   &result,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "eth", result.Value)
+
+	_, err = httplib.NewTLSReloader(httplib.TLSFiles{CertFile: files.CertFile})
+	require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package httplib
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/DeBankDeFi/golib/shared"
+)
+
+// DefaultAppNameHeader header carrying the application name of the caller.
+const DefaultAppNameHeader = "X-App-Name"
+
+// TracePropagation configures the tracing headers sent with requests, so that downstream
+// services can correlate their logs with the caller. Clients send none by default, enable it
+// with SetTracePropagation for internal services only as the headers leak to every host.
+type TracePropagation struct {
+	// TraceIDHeader header carrying RequestArgs.TraceID, or the trace id of the request
+	// context if empty. An empty header name disables it.
+	TraceIDHeader string
+	// AppNameHeader header carrying shared.GetAppName(), an empty header name disables it.
+	AppNameHeader string
+	// Traceparent sends a W3C traceparent header derived from the trace id.
+	Traceparent bool
+	// Hosts limits the headers to requests to these hosts, a leading dot matches subdomains,
+	// e.g. ".svc.cluster.local". Empty means all hosts.
+	Hosts []string
+}
+
+// DefaultTracePropagation returns the default header names, new clients do not propagate
+// until it is passed to SetTracePropagation.
+func DefaultTracePropagation() TracePropagation {
+	return TracePropagation{
+		TraceIDHeader: DefaultTraceIDHeader,
+		AppNameHeader: DefaultAppNameHeader,
+	}
+}
+
+// SetTracePropagation 设置链路追踪相关的请求头, 默认不发送, 传入零值表示关闭
+// 请求头会发往所有host, 对外部服务使用时需设置Hosts
+func (c *HTTPClient) SetTracePropagation(p TracePropagation) *HTTPClient {
+	c.tracePropagation = p
+	return c
+}
+
+// setTraceHeaders 设置链路追踪请求头, 调用者显式设置的请求头优先
+func (c *HTTPClient) setTraceHeaders(req *http.Request, traceID string) {
+	p := c.tracePropagation
+	if !p.matchHost(req.URL.Hostname()) {
+		return
+	}
+	if traceID != "" && p.TraceIDHeader != "" {
+		req.Header.Set(p.TraceIDHeader, traceID)
+	}
+	if p.AppNameHeader != "" {
+		if appName := shared.GetAppName(); appName != "" {
+			req.Header.Set(p.AppNameHeader, appName)
+		}
+	}
+	if traceID != "" && p.Traceparent {
+		req.Header.Set("traceparent", traceparent(traceID))
+	}
+}
+
+// matchHost reports whether headers are sent to host.
+func (p *TracePropagation) matchHost(host string) bool {
+	if len(p.Hosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, h := range p.Hosts {
+		h = strings.ToLower(h)
+		if host == h || (strings.HasPrefix(h, ".") && (strings.HasSuffix(host, h) || host == h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceparent builds a sampled W3C trace context, trace ids which are not 16 hex bytes
+// are hashed into one.
+func traceparent(traceID string) string {
+	tid := strings.ToLower(strings.ReplaceAll(traceID, "-", ""))
+	if !isHex(tid, 32) || tid == strings.Repeat("0", 32) {
+		sum := sha256.Sum256([]byte(traceID))
+		tid = hex.EncodeToString(sum[:16])
+	}
+	span := make([]byte, 8)
+	rand.Read(span)
+	// an all zero parent id is invalid
+	span[0] |= 1
+	return "00-" + tid + "-" + hex.EncodeToString(span) + "-01"
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
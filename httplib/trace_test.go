@@ -0,0 +1,75 @@
+package httplib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DeBankDeFi/golib/httplib"
+	"github.com/DeBankDeFi/golib/shared"
+	"github.com/DeBankDeFi/golib/util"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracePropagation(t *testing.T) {
+	var received http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer srv.Close()
+	shared.SetAppName("httplib-test")
+
+	// nothing is sent unless enabled
+	client := httplib.NewHTTPClient()
+	err := client.Get(context.Background(), &httplib.RequestArgs{TraceID: "tid-args", URL: srv.URL})
+	require.NoError(t, err)
+	require.Empty(t, received.Get(httplib.DefaultTraceIDHeader))
+	require.Empty(t, received.Get(httplib.DefaultAppNameHeader))
+
+	client.SetTracePropagation(httplib.DefaultTracePropagation())
+	err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "tid-args", URL: srv.URL})
+	require.NoError(t, err)
+	require.Equal(t, "tid-args", received.Get(httplib.DefaultTraceIDHeader))
+	require.Equal(t, "httplib-test", received.Get(httplib.DefaultAppNameHeader))
+	require.Empty(t, received.Get("traceparent"))
+
+	// fall back to the trace id of ctx, explicit headers take precedence
+	ctx := util.SetTraceIDToContext(context.Background(), "0af7651916cd43dd8448eb211c80319c")
+	client.SetTracePropagation(httplib.TracePropagation{
+		TraceIDHeader: "X-Request-Trace",
+		AppNameHeader: "X-Caller",
+		Traceparent:   true,
+	})
+	err = client.Get(ctx, &httplib.RequestArgs{URL: srv.URL, Headers: map[string]string{"X-Caller": "override"}})
+	require.NoError(t, err)
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", received.Get("X-Request-Trace"))
+	require.Equal(t, "override", received.Get("X-Caller"))
+	require.Regexp(t, regexp.MustCompile(`^00-0af7651916cd43dd8448eb211c80319c-[0-9a-f]{16}-01$`), received.Get("traceparent"))
+
+	// non hex trace ids are hashed into a valid traceparent
+	err = client.Get(context.Background(), &httplib.RequestArgs{TraceID: "not-a-hex-id", URL: srv.URL})
+	require.NoError(t, err)
+	require.Regexp(t, regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`), received.Get("traceparent"))
+
+	// limited to internal hosts
+	p := httplib.DefaultTracePropagation()
+	p.Hosts = []string{".svc.cluster.local"}
+	client.SetTracePropagation(p)
+	err = client.Get(ctx, &httplib.RequestArgs{URL: srv.URL})
+	require.NoError(t, err)
+	require.Empty(t, received.Get(httplib.DefaultTraceIDHeader))
+	p.Hosts = []string{"api.svc.cluster.local", "127.0.0.1"}
+	client.SetTracePropagation(p)
+	err = client.Get(ctx, &httplib.RequestArgs{URL: srv.URL})
+	require.NoError(t, err)
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", received.Get(httplib.DefaultTraceIDHeader))
+
+	client.SetTracePropagation(httplib.TracePropagation{})
+	err = client.Get(ctx, &httplib.RequestArgs{URL: srv.URL})
+	require.NoError(t, err)
+	require.Empty(t, received.Get(httplib.DefaultTraceIDHeader))
+	require.Empty(t, received.Get(httplib.DefaultAppNameHeader))
+}
@@ -0,0 +1,152 @@
+package httplib
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// TransportConfig tunes the long-lived connection pool of an HTTPClient.
+// Zero fields fall back to the values of DefaultTransportConfig.
+type TransportConfig struct {
+	// MaxIdleConns idle connections kept across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost idle connections kept per host.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits connections per host including active ones, 0 means unlimited.
+	MaxConnsPerHost int
+	// IdleConnTimeout how long an idle connection is kept.
+	IdleConnTimeout time.Duration
+	// DialTimeout timeout of establishing a tcp connection.
+	DialTimeout time.Duration
+	// KeepAlive interval of tcp keep-alive probes.
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout timeout of the tls handshake.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout timeout waiting for response headers after the request is written, 0 means no timeout.
+	ResponseHeaderTimeout time.Duration
+	// DisableHTTP2 only speak HTTP/1.1.
+	DisableHTTP2 bool
+	// Proxy returns the proxy of a request, defaults to http.ProxyFromEnvironment.
+	// Use http.ProxyURL for a fixed proxy, or a func returning nil to disable proxies.
+	Proxy func(*http.Request) (*url.URL, error)
+}
+
+// DefaultTransportConfig returns the transport configuration used by new clients.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		DialTimeout:         10 * time.Second,
+		KeepAlive:           30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		Proxy:               http.ProxyFromEnvironment,
+	}
+}
+
+func (cfg TransportConfig) withDefaults() TransportConfig {
+	def := DefaultTransportConfig()
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = def.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost <= 0 {
+		cfg.MaxIdleConnsPerHost = def.MaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout <= 0 {
+		cfg.IdleConnTimeout = def.IdleConnTimeout
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = def.DialTimeout
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = def.KeepAlive
+	}
+	if cfg.TLSHandshakeTimeout <= 0 {
+		cfg.TLSHandshakeTimeout = def.TLSHandshakeTimeout
+	}
+	if cfg.Proxy == nil {
+		cfg.Proxy = def.Proxy
+	}
+	return cfg
+}
+
+// newTransport builds a transport, if tlsConfig is non-nil it is used for https connections.
+func newTransport(cfg TransportConfig, tlsConfig *tls.Config) *http.Transport {
+	cfg = cfg.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	t := &http.Transport{
+		Proxy:                 cfg.Proxy,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// a non-nil empty map disables the automatic HTTP/2 upgrade
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+// SetTransportConfig replaces the connection pool of the client, idle connections of the
+// previous pool are closed.
+func (c *HTTPClient) SetTransportConfig(cfg TransportConfig) *HTTPClient {
+	old := c.transport
+	c.transport = newTransport(cfg, c.tlsConfig)
+	if old != nil {
+		old.CloseIdleConnections()
+	}
+	return c
+}
+
+// Close releases the idle connections of the client, the client remains usable.
+func (c *HTTPClient) Close() {
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+}
+
+// httpClient returns a client sharing the long-lived transport wrapped by the response cache
+// and the middlewares.
+func (c *HTTPClient) httpClient() *http.Client {
+	return &http.Client{
+		Transport: c.roundTripper(nil),
+		Timeout:   c.timeout,
+	}
+}
+
+// streamClient returns a client for responses which are read by the caller, the timeout
+// bounds the wait for the headers and each read of the body instead of the whole request.
+func (c *HTTPClient) streamClient() *http.Client {
+	return &http.Client{
+		Transport: c.roundTripper(func(rt http.RoundTripper) http.RoundTripper {
+			return &idleTimeoutTransport{next: rt, timeout: c.timeout}
+		}),
+	}
+}
+
+func (c *HTTPClient) roundTripper(wrap func(http.RoundTripper) http.RoundTripper) http.RoundTripper {
+	var rt http.RoundTripper = http.DefaultTransport
+	if c.transport != nil {
+		rt = c.transport
+	}
+	if wrap != nil {
+		rt = wrap(rt)
+	}
+	if c.cache != nil {
+		rt = c.cache.wrap(rt)
+	}
+	return c.chain(rt)
+}
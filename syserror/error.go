@@ -30,6 +30,13 @@ func WithFields(fields map[string]interface{}) Option {
 	}
 }
 
+// WithCause keeps the underlying error, so that errors.Is and errors.As see through the SysError.
+func WithCause(err error) Option {
+	return func(e *SysError) {
+		e.cause = err
+	}
+}
+
 type SysError struct {
 	TraceID      string
 	ID           string
@@ -38,6 +45,13 @@ type SysError struct {
 	ErrorAt      string
 	Wrapper      []string
 	MemoryValues map[string]interface{}
+
+	cause error
+}
+
+// Unwrap returns the error given by WithCause.
+func (e *SysError) Unwrap() error {
+	return e.cause
 }
 
 func (e *SysError) Error() string {
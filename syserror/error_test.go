@@ -1,6 +1,7 @@
 package syserror_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -41,3 +42,24 @@ func TestNewErrorEx(t *testing.T) {
 	fmt.Printf("======================\n")
 	fmt.Println(syserror.StatusError(err))
 }
+
+type causeError struct{ status int }
+
+func (e *causeError) Error() string { return "cause" }
+
+func TestWithCause(t *testing.T) {
+	cause := &causeError{status: 404}
+	err := syserror.NewV2("tid", "ID", "Note", syserror.WithCause(cause))
+	err = syserror.Wrap(err, "A")
+
+	var target *causeError
+	if !errors.As(err, &target) || target.status != 404 {
+		t.Fatalf("cause not found in %v", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatal("errors.Is does not match the cause")
+	}
+	if errors.Unwrap(syserror.New("tid", "ID", "Note", nil)) != nil {
+		t.Fatal("unexpected cause")
+	}
+}